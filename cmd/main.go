@@ -2,75 +2,292 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"sber_test/internal/auth"
+	"sber_test/internal/config"
 	"sber_test/internal/handlers"
-	"sber_test/internal/repo/cache"
+	"sber_test/internal/idempotency"
+	"sber_test/internal/lifecycle"
+	"sber_test/internal/logging"
+	"sber_test/internal/metrics"
+	"sber_test/internal/ratelimit"
 	"sber_test/internal/service"
-	"strings"
+	"sber_test/internal/tracing"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi"
-	"gopkg.in/yaml.v2"
 )
 
-// Config struct.
-type Config struct {
-	Port int `yml:"port"`
+// serviceName names the service in exported traces.
+const serviceName = "calculator"
+
+func newIDs(scheme string) func() service.ID {
+	if scheme == config.IDsTimeOrdered {
+		return service.TimeOrderedIDs()
+	}
+	return service.SequentialIDs()
 }
 
-// BasePath - safe path.
-const BasePath = "C:\\GolangProgs\\sber_test"
+// newTracer returns the tracer configured by cfg and registers closing its
+// output, if any, with lc.
+func newTracer(cfg config.Trace, lc *lifecycle.Manager) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch cfg.Exporter {
+	case config.TraceStdout:
+		exporter = tracing.NewJSONExporter(os.Stdout, serviceName)
+	case config.TraceFile:
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open trace file: %w", err)
+		}
+		_ = lc.Add("trace file", func(context.Context) error { return f.Close() }) //nolint:errcheck // lc isn't shut down yet
+		exporter = tracing.NewJSONExporter(f, serviceName)
+	}
+	return tracing.New(exporter, tracing.WithSampleRatio(cfg.SampleRatio)), nil
+}
 
-func loadConfig(path string) Config {
-	absPath := filepath.Join(BasePath, path)
-	cleanPath := filepath.Clean(absPath)
+// newAuthenticator returns the authenticator configured by cfg, or nil if
+// authentication is disabled.
+func newAuthenticator(cfg config.Auth) (*auth.Authenticator, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	keys := make([]auth.APIKey, 0, len(cfg.APIKeys))
+	for _, k := range cfg.APIKeys {
+		keys = append(keys, auth.APIKey{Key: k.Key, ClientID: k.ClientID, Tenant: k.Tenant, Scopes: k.Scopes})
+	}
 
-	if !strings.HasPrefix(cleanPath, BasePath) {
-		log.Fatalf("invalid path: %v", cleanPath)
+	var jwtKeys []auth.Key
+	for _, path := range cfg.JWT.JWKSFiles {
+		set, err := auth.LoadJWKS(path)
+		if err != nil {
+			return nil, err //nolint:wrapcheck // names the file
+		}
+		jwtKeys = append(jwtKeys, set...)
+	}
+	for _, k := range cfg.JWT.Keys {
+		if k.Secret != "" {
+			jwtKeys = append(jwtKeys, auth.Key{ID: k.ID, Key: []byte(k.Secret)})
+			continue
+		}
+		pub, err := auth.LoadPublicKey(k.PublicKeyFile)
+		if err != nil {
+			return nil, err //nolint:wrapcheck // names the file
+		}
+		jwtKeys = append(jwtKeys, auth.Key{ID: k.ID, Key: pub})
+	}
+	var verifier *auth.JWTVerifier
+	if len(jwtKeys) > 0 {
+		verifier = auth.NewJWTVerifier(jwtKeys,
+			auth.WithIssuer(cfg.JWT.Issuer),
+			auth.WithAudience(cfg.JWT.Audience),
+			auth.WithLeeway(cfg.JWT.Leeway),
+		)
 	}
+	return auth.New(keys, verifier) //nolint:wrapcheck // reported as a config error
+}
 
-	b, err := os.ReadFile(cleanPath)
+// checkHealth queries /healthz on port and returns the exit code for the
+// -healthcheck flag. It lets the Docker HEALTHCHECK work in an image that
+// has nothing but the binary.
+func checkHealth(port int) int {
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/healthz", port))
 	if err != nil {
-		log.Fatalf("failed to read config: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
-	var cfg Config
-	if err := yaml.Unmarshal(b, &cfg); err != nil {
-		log.Fatalf("failed to parse config: %v", err)
+	_ = resp.Body.Close() //nolint:errcheck // the body isn't read
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "unhealthy:", resp.Status)
+		return 1
 	}
-	return cfg
+	return 0
 }
 
 func main() {
-	cfg := loadConfig("config.yml")
+	defaultPath := "config.yml"
+	if path, ok := os.LookupEnv(config.EnvPrefix + "CONFIG"); ok {
+		defaultPath = path
+	}
+	configPath := flag.String("config", defaultPath, "path to the YAML config; settings can be overridden by "+config.EnvPrefix+"* variables")
+	healthcheck := flag.Bool("healthcheck", false, "query /healthz of the server on the configured port and exit with 0 if it is healthy")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath, os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *healthcheck {
+		os.Exit(checkHealth(cfg.Port))
+	}
+	level, _ := cfg.Log.SlogLevel() //nolint:errcheck // checked by config.Load
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Вывод пакета log тоже идёт через этот логгер
+	slog.SetDefault(logger)
+
+	// Выходим только здесь, после того как отработали все defer в run
+	if err := run(cfg); err != nil {
+		slog.Error("server failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+// run serves the API configured by cfg until it fails or a termination
+// signal arrives, then shuts everything down.
+func run(cfg config.Config) error {
 	addr := fmt.Sprintf(":%d", cfg.Port)
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	lc := lifecycle.New()
+
+	tracer, err := newTracer(cfg.Trace, lc)
+	if err != nil {
+		return err
+	}
+	tracing.SetDefault(tracer)
+
 	// Создаём экземпляр кеша (предположительно, Cache уже настроен)
-	c := cache.New()
+	c := service.NewStore(newIDs(cfg.Cache.IDs), cfg.Cache.MaxItems)
+
+	// Создаем экземпляр Service, передавая в него кеш; остальные арендаторы
+	// получают свои кеши с тем же генератором ID
+	svcOpts := []service.Option{
+		service.WithProgramsFile(cfg.ProgramsFile),
+		service.WithTenantLimits(cfg.Tenancy.MaxTenants, cfg.Tenancy.IdleTimeout),
+	}
+	for id, t := range cfg.Tenancy.Tenants {
+		svcOpts = append(svcOpts, service.WithTenant(id, service.TenantConfig{
+			RetainItems:  t.RetainItems,
+			ProgramsFile: t.ProgramsFile,
+		}))
+	}
+	svc := service.New(c, svcOpts...)
 
-	// Создаем экземпляр Service, передавая в него кеш
-	svc := service.New(c)
+	// Ключи API и JWT; nil, если аутентификация выключена
+	authenticator, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		return err
+	}
 
 	// Создаём новый маршрутизатор chi
 	r := chi.NewRouter()
 
 	// Регистрируем маршруты через handler
-	handlers.RegisterRoutes(r, svc)
+	routeOpts := []handlers.Option{
+		handlers.WithBatchLimits(handlers.BatchLimits{
+			MaxSize: cfg.Batch.MaxSize,
+			Workers: cfg.Batch.Workers,
+		}),
+		handlers.WithImportLimits(handlers.ImportLimits{MaxRows: cfg.Import.MaxRows}),
+		handlers.WithAuth(authenticator),
+		handlers.WithMaxInFlight(cfg.RateLimit.MaxInFlight),
+	}
+	if len(cfg.RateLimit.Routes) > 0 {
+		limits := make(map[string]ratelimit.Limit, len(cfg.RateLimit.Routes))
+		for route, l := range cfg.RateLimit.Routes {
+			limits[route] = ratelimit.Limit{Rate: l.Rate, Burst: l.Burst}
+		}
+		routeOpts = append(routeOpts, handlers.WithRateLimits(limits, ratelimit.WithMaxKeys(cfg.RateLimit.MaxClients)))
+	}
+	if cfg.Idempotency.TTL > 0 {
+		// Первые ответы на запросы с Idempotency-Key
+		keys := idempotency.New(cfg.Idempotency.TTL,
+			idempotency.WithMaxEntries(cfg.Idempotency.MaxKeys),
+			idempotency.WithMaxBodySize(cfg.Idempotency.MaxBodySize))
+		metrics.Default.NewGaugeFunc("calculator_idempotency_keys", "Idempotency keys remembered.", func() float64 {
+			return float64(keys.Len())
+		})
+		metrics.Default.NewCounterFunc("calculator_idempotency_evictions_total", "Idempotency keys evicted to stay within max_keys.", func() float64 {
+			return float64(keys.Evictions())
+		})
+		routeOpts = append(routeOpts, handlers.WithIdempotency(keys))
+	}
+	if cfg.Tenancy.TrustHeader {
+		routeOpts = append(routeOpts, handlers.WithTrustedTenantHeader())
+	}
+	handlers.RegisterRoutes(r, svc, routeOpts...)
+
+	// Метрики в формате Prometheus
+	metrics.Default.NewGaugeFunc("calculator_cache_items", "Calculations held in the caches of all tenants.", func() float64 {
+		return float64(svc.Len())
+	})
+	metrics.Default.NewCounterFunc("calculator_cache_evictions_total", "Calculations evicted from the caches to keep tenants within cache.max_items or their retain_items.", func() float64 {
+		return float64(svc.Evictions())
+	})
+	metrics.Default.NewGaugeFunc("calculator_tenants", "Tenants whose calculations are kept.", func() float64 {
+		return float64(svc.Tenants())
+	})
+	metrics.Default.NewCounterFunc("calculator_tenants_dropped_total", "Tenants dropped with their calculations by tenancy.max_tenants or idle_timeout.", func() float64 {
+		return float64(svc.DroppedTenants())
+	})
+	metrics.Default.NewCounterFunc("calculator_trace_export_failures_total", "Spans the trace exporter failed to write.", func() float64 {
+		return float64(tracer.ExportFailures())
+	})
+	// Для /metrics нужно право metrics:read, если включена аутентификация
+	handlers.RegisterMetrics(r, metrics.Default, authenticator)
+
+	// Пробы для оркестратора и версия сборки
+	health := handlers.NewHealth(svc)
+	handlers.RegisterProbes(r, health)
 
 	// Настроить сервер
 	server := &http.Server{
 		Addr:         addr,
 		Handler:      r,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  30 * time.Second,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	// Компоненты останавливаются в обратном порядке: сервер добавлен
+	// последним, поэтому сначала перестаёт принимать запросы
+	_ = lc.Add("http server", server.Shutdown) //nolint:errcheck // lc isn't shut down yet
+	// А ещё раньше /readyz начинает отвечать 503, чтобы балансировщик
+	// успел перестать слать запросы
+	_ = lc.Add("readiness", func(ctx context.Context) error { //nolint:errcheck // lc isn't shut down yet
+		health.Drain()
+		select {
+		case <-time.After(cfg.DrainDelay):
+		case <-ctx.Done():
+		}
+		return nil
+	})
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("server is running", "addr", addr)
+		errc <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("start server: %w", err)
+		}
+	case <-ctx.Done():
+		stop()
+		slog.Info("shutting down, waiting for in-flight requests", "timeout", cfg.ShutdownTimeout)
 	}
 
-	log.Printf("Server is running on %s\n", addr)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := lc.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
 	}
+	slog.Info("server stopped")
+	return nil
 }
@@ -0,0 +1,148 @@
+// Package auth authenticates API clients by static API keys or by JWTs
+// signed with locally configured keys, and carries the resulting principal
+// through request contexts.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"sber_test/internal/tenant"
+)
+
+// Scopes grant access to groups of routes.
+const (
+	// ScopeExecute allows calculations, including batches and imports.
+	ScopeExecute = "calc:execute"
+	// ScopeCacheRead allows listing and exporting cached calculations.
+	ScopeCacheRead = "cache:read"
+	// ScopeAdminPrograms allows inspecting the program catalogue.
+	ScopeAdminPrograms = "admin:programs"
+	// ScopeMetricsRead allows scraping /metrics.
+	ScopeMetricsRead = "metrics:read"
+)
+
+// Scopes lists every scope, in the order above.
+var Scopes = []string{ScopeExecute, ScopeCacheRead, ScopeAdminPrograms, ScopeMetricsRead}
+
+// Credential headers. API keys are sent in HeaderAPIKey, JWTs as
+// "Authorization: Bearer <token>".
+const (
+	HeaderAPIKey        = "X-API-Key"
+	HeaderAuthorization = "Authorization"
+)
+
+// Errors returned by Authenticate.
+var (
+	// ErrNoCredentials means the request carries neither an API key nor a
+	// bearer token.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials means the credentials were not accepted.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated client.
+type Principal struct {
+	ClientID string
+	// Tenant is the tenant the client acts for. Clients of one tenant share
+	// its cached calculations.
+	Tenant string
+	Scopes []string
+}
+
+// Has reports whether p was granted scope.
+func (p Principal) Has(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// Anonymous is the principal of every request when authentication is
+// disabled. It has every scope.
+var Anonymous = Principal{Scopes: Scopes}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// ClientID returns the ID of the principal carried by ctx, or "".
+func ClientID(ctx context.Context) string {
+	p, _ := FromContext(ctx)
+	return p.ClientID
+}
+
+// APIKey is a static key granting a client its scopes. The client acts for
+// Tenant, or else for a tenant of its own named after ClientID, which must
+// then be a valid tenant ID.
+type APIKey struct {
+	Key      string
+	ClientID string
+	Tenant   string
+	Scopes   []string
+}
+
+// Authenticator checks the credentials of requests.
+type Authenticator struct {
+	// apiKeys is indexed by the SHA-256 of the key, so that lookups don't
+	// leak the key through timing.
+	apiKeys map[[sha256.Size]byte]Principal
+	jwt     *JWTVerifier
+}
+
+// New returns an Authenticator accepting keys and, if jwt is not nil, the
+// tokens it verifies. Keys must be unique.
+func New(keys []APIKey, jwt *JWTVerifier) (*Authenticator, error) {
+	a := &Authenticator{apiKeys: make(map[[sha256.Size]byte]Principal, len(keys)), jwt: jwt}
+	for _, k := range keys {
+		sum := sha256.Sum256([]byte(k.Key))
+		if _, dup := a.apiKeys[sum]; dup {
+			return nil, fmt.Errorf("duplicate API key of client %s", k.ClientID)
+		}
+		p := Principal{ClientID: k.ClientID, Tenant: k.Tenant, Scopes: k.Scopes}
+		if p.Tenant == "" {
+			p.Tenant = p.ClientID
+		}
+		if !tenant.Valid(p.Tenant) {
+			return nil, fmt.Errorf("invalid tenant %q of client %s", p.Tenant, k.ClientID)
+		}
+		a.apiKeys[sum] = p
+	}
+	return a, nil
+}
+
+// Authenticate returns the principal of the credentials sent with r. It
+// returns ErrNoCredentials if there are none and an error wrapping
+// ErrInvalidCredentials if they are rejected.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	if key := r.Header.Get(HeaderAPIKey); key != "" {
+		return a.apiKey(key)
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get(HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Principal{}, ErrNoCredentials
+	}
+	if a.jwt == nil {
+		return Principal{}, fmt.Errorf("%w: bearer tokens are not accepted", ErrInvalidCredentials)
+	}
+	return a.jwt.Verify(strings.TrimSpace(token))
+}
+
+func (a *Authenticator) apiKey(key string) (Principal, error) {
+	p, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Principal{}, fmt.Errorf("%w: unknown API key", ErrInvalidCredentials)
+	}
+	return p, nil
+}
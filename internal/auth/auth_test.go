@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign builds a JWT with the given header and claims signed by key, which
+// is a []byte secret or an RSA or ECDSA private key.
+func sign(t *testing.T, alg, kid string, claims map[string]any, key any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	hash := algorithms[alg].hash
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func claims(extra map[string]any) map[string]any {
+	c := map[string]any{"sub": "bank-a", "exp": now.Add(time.Hour).Unix(), "scope": "calc:execute cache:read"}
+	for k, v := range extra {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func TestJWTAlgorithms(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewJWTVerifier([]Key{
+		{ID: "hs", Key: secret},
+		{ID: "rs", Key: &rsaKey.PublicKey},
+		{ID: "es", Key: &ecKey.PublicKey, Algorithm: "ES256"},
+	}, WithClock(func() time.Time { return now }))
+
+	want := Principal{ClientID: "bank-a", Tenant: "bank-a", Scopes: []string{ScopeExecute, ScopeCacheRead}}
+	for _, token := range []string{
+		sign(t, "HS256", "hs", claims(nil), secret),
+		sign(t, "HS512", "", claims(nil), secret),
+		sign(t, "RS256", "rs", claims(nil), rsaKey),
+		sign(t, "RS384", "", claims(nil), rsaKey),
+		sign(t, "ES256", "es", claims(nil), ecKey),
+	} {
+		p, err := v.Verify(token)
+		if err != nil {
+			t.Errorf("expected the token accepted, got %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("expected %+v, got %+v", want, p)
+		}
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{
+		"wrong key":         sign(t, "HS256", "hs", claims(nil), []byte("another secret")),
+		"kid mismatch":      sign(t, "RS256", "hs", claims(nil), rsaKey),
+		"algorithm pinned":  sign(t, "ES384", "es", claims(nil), ecKey),
+		"public key as MAC": sign(t, "HS256", "rs", claims(nil), pubDER),
+		"none":              b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"sub":"x","exp":9999999999}`)) + ".",
+		"malformed":         "a.b",
+	} {
+		if _, err := v.Verify(token); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", name, err)
+		}
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	v := NewJWTVerifier([]Key{{Key: secret}},
+		WithClock(func() time.Time { return now }),
+		WithIssuer("https://idp.example"),
+		WithAudience("calculator"),
+		WithLeeway(time.Minute),
+	)
+	valid := map[string]any{"iss": "https://idp.example", "aud": []string{"other", "calculator"}}
+	with := func(extra map[string]any) map[string]any {
+		c := claims(valid)
+		for k, val := range extra {
+			if val == nil {
+				delete(c, k)
+			} else {
+				c[k] = val
+			}
+		}
+		return c
+	}
+
+	p, err := v.Verify(sign(t, "HS256", "", with(map[string]any{
+		"client_id": "mobile", "tenant": "bank-b", "scope": nil, "scp": []string{ScopeAdminPrograms},
+		"exp": now.Add(-30 * time.Second).Unix(), "nbf": now.Add(30 * time.Second).Unix(),
+	}), secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Principal{ClientID: "mobile", Tenant: "bank-b", Scopes: []string{ScopeAdminPrograms}}); !reflect.DeepEqual(p, want) {
+		t.Errorf("expected %+v within the leeway, got %+v", want, p)
+	}
+
+	for name, c := range map[string]map[string]any{
+		"expired":                   with(map[string]any{"exp": now.Add(-2 * time.Minute).Unix()}),
+		"no exp":                    with(map[string]any{"exp": nil}),
+		"not yet valid":             with(map[string]any{"nbf": now.Add(2 * time.Minute).Unix()}),
+		"issuer":                    with(map[string]any{"iss": "https://evil.example"}),
+		"audience":                  with(map[string]any{"aud": "other"}),
+		"no audience":               with(map[string]any{"aud": nil}),
+		"invalid tenant":            with(map[string]any{"tenant": "bank b"}),
+		"invalid subject as tenant": with(map[string]any{"sub": "user/" + strings.Repeat("x", 64)}),
+		"no subject":                with(map[string]any{"sub": nil}),
+	} {
+		if _, err := v.Verify(sign(t, "HS256", "", c, secret)); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 48
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rs", "alg": "RS256", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "es", "crv": "P-384", "x": b64(ecKey.X.FillBytes(make([]byte, size))), "y": b64(ecKey.Y.FillBytes(make([]byte, size)))},
+		{"kty": "oct", "kid": "hs", "k": b64([]byte("secret"))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+	jwksPath := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksPath, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadJWKS(jwksPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[0].Algorithm != "RS256" {
+		t.Fatalf("expected 3 signing keys, got %+v", keys)
+	}
+	v := NewJWTVerifier(keys, WithClock(func() time.Time { return now }))
+	for _, token := range []string{
+		sign(t, "RS256", "rs", claims(nil), rsaKey),
+		sign(t, "ES384", "es", claims(nil), ecKey),
+		sign(t, "HS256", "hs", claims(nil), []byte("secret")),
+	} {
+		if _, err := v.Verify(token); err != nil {
+			t.Errorf("expected a token signed with a JWKS key accepted, got %v", err)
+		}
+	}
+
+	if _, err := ParseJWKS([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`)); err == nil {
+		t.Error("expected a point off the curve rejected")
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadPublicKey(pemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecKey.PublicKey.Equal(key) {
+		t.Error("expected the PEM key loaded")
+	}
+	if _, err := LoadPublicKey(jwksPath); err == nil {
+		t.Error("expected an error for a file without a PEM block")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	a, err := New([]APIKey{
+		{Key: "k1", ClientID: "bank-a", Scopes: []string{ScopeCacheRead}},
+		{Key: "k3", ClientID: "mobile", Tenant: "bank-a"},
+	},
+		NewJWTVerifier([]Key{{Key: secret}}, WithClock(func() time.Time { return now })))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, err := a.Authenticate(req); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("expected ErrNoCredentials, got %v", err)
+	}
+
+	req.Header.Set(HeaderAPIKey, "k1")
+	if p, err := a.Authenticate(req); err != nil || p.ClientID != "bank-a" || p.Tenant != "bank-a" || !p.Has(ScopeCacheRead) || p.Has(ScopeExecute) {
+		t.Errorf("unexpected principal %+v, %v", p, err)
+	}
+	req.Header.Set(HeaderAPIKey, "k3")
+	if p, err := a.Authenticate(req); err != nil || p.ClientID != "mobile" || p.Tenant != "bank-a" {
+		t.Errorf("expected the configured tenant, got %+v, %v", p, err)
+	}
+	req.Header.Set(HeaderAPIKey, "k2")
+	if _, err := a.Authenticate(req); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected an unknown key rejected, got %v", err)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(HeaderAuthorization, "Bearer "+sign(t, "HS256", "", claims(nil), secret))
+	if p, err := a.Authenticate(req); err != nil || p.ClientID != "bank-a" || !p.Has(ScopeExecute) {
+		t.Errorf("unexpected principal %+v, %v", p, err)
+	}
+	req.Header.Set(HeaderAuthorization, "Basic dXNlcjpwYXNz")
+	if _, err := a.Authenticate(req); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("expected other schemes ignored, got %v", err)
+	}
+
+	if _, err := New([]APIKey{{Key: "k", ClientID: "a"}, {Key: "k", ClientID: "b"}}, nil); err == nil {
+		t.Error("expected duplicate keys rejected")
+	}
+	if _, err := New([]APIKey{{Key: "k", ClientID: "bank a"}}, nil); err == nil {
+		t.Error("expected a client ID that is no valid tenant rejected without a tenant")
+	}
+}
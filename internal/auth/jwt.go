@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"sber_test/internal/tenant"
+
+	// Register the hashes used by the supported algorithms.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Key is a key JWTs may be signed with: a []byte HMAC secret, an
+// *rsa.PublicKey or an *ecdsa.PublicKey.
+type Key struct {
+	// ID is matched against the kid header of tokens. Tokens without a kid
+	// are tried against every key of their algorithm.
+	ID  string
+	Key any
+	// Algorithm, if set, is the only algorithm the key is accepted for.
+	Algorithm string
+}
+
+// algorithm describes a supported JWS algorithm.
+type algorithm struct {
+	hash   crypto.Hash
+	verify func(key any, hash crypto.Hash, signed, sig []byte) bool
+}
+
+var algorithms = map[string]algorithm{
+	"HS256": {crypto.SHA256, verifyHMAC},
+	"HS384": {crypto.SHA384, verifyHMAC},
+	"HS512": {crypto.SHA512, verifyHMAC},
+	"RS256": {crypto.SHA256, verifyRSA},
+	"RS384": {crypto.SHA384, verifyRSA},
+	"RS512": {crypto.SHA512, verifyRSA},
+	"ES256": {crypto.SHA256, verifyECDSA},
+	"ES384": {crypto.SHA384, verifyECDSA},
+	"ES512": {crypto.SHA512, verifyECDSA},
+}
+
+// The verify functions check the key type themselves, so a token can't pick
+// an algorithm that makes a public key act as an HMAC secret.
+
+func verifyHMAC(key any, hash crypto.Hash, signed, sig []byte) bool {
+	secret, ok := key.([]byte)
+	if !ok {
+		return false
+	}
+	mac := hmac.New(hash.New, secret)
+	mac.Write(signed)
+	return hmac.Equal(mac.Sum(nil), sig)
+}
+
+func verifyRSA(key any, hash crypto.Hash, signed, sig []byte) bool {
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return false
+	}
+	h := hash.New()
+	h.Write(signed)
+	return rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig) == nil
+}
+
+func verifyECDSA(key any, hash crypto.Hash, signed, sig []byte) bool {
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	if len(sig) != 2*size {
+		return false
+	}
+	h := hash.New()
+	h.Write(signed)
+	r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+	return ecdsa.Verify(pub, h.Sum(nil), r, s)
+}
+
+// JWTVerifier checks signed JWTs and turns their claims into principals.
+// The client ID is the client_id claim, or else sub; the tenant is the
+// tenant claim, or else the client ID; scopes come from the space-separated
+// scope claim or the scp list. Tokens whose tenant, named or derived, isn't
+// a valid tenant ID are rejected.
+type JWTVerifier struct {
+	keys     []Key
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// JWTOption configures a JWTVerifier.
+type JWTOption func(*JWTVerifier)
+
+// WithIssuer requires the iss claim to be issuer.
+func WithIssuer(issuer string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience requires the aud claim to contain audience.
+func WithAudience(audience string) JWTOption {
+	return func(v *JWTVerifier) {
+		v.audience = audience
+	}
+}
+
+// WithLeeway tolerates clock skew of up to d when checking exp and nbf.
+func WithLeeway(d time.Duration) JWTOption {
+	return func(v *JWTVerifier) {
+		v.leeway = d
+	}
+}
+
+// WithClock makes the verifier take the time from now.
+func WithClock(now func() time.Time) JWTOption {
+	return func(v *JWTVerifier) {
+		v.now = now
+	}
+}
+
+// NewJWTVerifier returns a verifier accepting tokens signed with keys.
+func NewJWTVerifier(keys []Key, opts ...JWTOption) *JWTVerifier {
+	v := &JWTVerifier{keys: keys, now: time.Now}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	ClientID  string          `json:"client_id"`
+	Tenant    string          `json:"tenant"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *json.Number    `json:"exp"`
+	NotBefore *json.Number    `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scp       json.RawMessage `json:"scp"`
+}
+
+// Verify checks the signature and claims of token and returns its
+// principal. Tokens must expire. Errors wrap ErrInvalidCredentials.
+func (v *JWTVerifier) Verify(token string) (Principal, error) {
+	p, err := v.verify(token)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+	return p, nil
+}
+
+func (v *JWTVerifier) verify(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("header: %w", err)
+	}
+	alg, ok := algorithms[header.Alg]
+	if !ok {
+		return Principal{}, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("signature: %w", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range v.keys {
+		if (header.Kid != "" && k.ID != header.Kid) || (k.Algorithm != "" && k.Algorithm != header.Alg) {
+			continue
+		}
+		if alg.verify(k.Key, alg.hash, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Principal{}, errors.New("signature not verified")
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, fmt.Errorf("claims: %w", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return Principal{}, err
+	}
+	p := Principal{ClientID: claims.ClientID, Tenant: claims.Tenant, Scopes: strings.Fields(claims.Scope)}
+	if p.ClientID == "" {
+		p.ClientID = claims.Subject
+	}
+	if p.ClientID == "" {
+		return Principal{}, errors.New("no client_id or sub claim")
+	}
+	switch {
+	case claims.Tenant != "" && !tenant.Valid(claims.Tenant):
+		return Principal{}, fmt.Errorf("invalid tenant claim %q", claims.Tenant)
+	case claims.Tenant == "" && !tenant.Valid(p.ClientID):
+		return Principal{}, fmt.Errorf("client ID %q is no valid tenant ID and there is no tenant claim", p.ClientID)
+	case claims.Tenant == "":
+		p.Tenant = p.ClientID
+	}
+	if len(claims.Scp) > 0 {
+		scp, err := stringOrList(claims.Scp)
+		if err != nil {
+			return Principal{}, fmt.Errorf("scp claim: %w", err)
+		}
+		p.Scopes = append(p.Scopes, scp...)
+	}
+	return p, nil
+}
+
+func (v *JWTVerifier) checkClaims(c jwtClaims) error {
+	now := v.now()
+	if c.ExpiresAt == nil {
+		return errors.New("no exp claim")
+	}
+	exp, err := numericDate(*c.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("exp claim: %w", err)
+	}
+	if !now.Before(exp.Add(v.leeway)) {
+		return errors.New("token expired")
+	}
+	if c.NotBefore != nil {
+		nbf, err := numericDate(*c.NotBefore)
+		if err != nil {
+			return fmt.Errorf("nbf claim: %w", err)
+		}
+		if now.Add(v.leeway).Before(nbf) {
+			return errors.New("token not valid yet")
+		}
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return fmt.Errorf("unexpected issuer %q", c.Issuer)
+	}
+	if v.audience != "" {
+		aud, err := stringOrList(c.Audience)
+		if err != nil {
+			return fmt.Errorf("aud claim: %w", err)
+		}
+		found := false
+		for _, a := range aud {
+			found = found || a == v.audience
+		}
+		if !found {
+			return fmt.Errorf("token not meant for audience %q", v.audience)
+		}
+	}
+	return nil
+}
+
+func decodeSegment(s string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err //nolint:wrapcheck // wrapped by the caller
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	return dec.Decode(v) //nolint:wrapcheck // wrapped by the caller
+}
+
+// numericDate converts seconds since the epoch, possibly fractional.
+func numericDate(n json.Number) (time.Time, error) {
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err //nolint:wrapcheck // wrapped by the caller
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), nil
+}
+
+// stringOrList decodes a claim that is either a string or a list of them.
+func stringOrList(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, errors.New("must be a string or a list of strings")
+	}
+	return list, nil
+}
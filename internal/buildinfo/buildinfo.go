@@ -0,0 +1,51 @@
+// Package buildinfo describes the running binary.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Commit and BuildTime are set at link time, e.g.
+//
+//	go build -ldflags "-X sber_test/internal/buildinfo.Commit=$(git rev-parse HEAD)
+//	  -X sber_test/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When they are not, the VCS details the go command stamps into binaries
+// built inside a repository are used instead.
+var (
+	Commit    string
+	BuildTime string
+)
+
+// Unknown stands for details that are not available.
+const Unknown = "unknown"
+
+// Info describes a build.
+type Info struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Read returns the details of the running binary.
+func Read() Info {
+	info := Info{Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = Unknown
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = Unknown
+	}
+	return info
+}
@@ -0,0 +1,21 @@
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	defer func(commit, buildTime string) { Commit, BuildTime = commit, buildTime }(Commit, BuildTime)
+
+	Commit, BuildTime = "", ""
+	info := Read()
+	if info.GoVersion != runtime.Version() || info.Commit == "" || info.BuildTime == "" {
+		t.Errorf("unexpected build info %+v", info)
+	}
+
+	Commit, BuildTime = "abc123", "2024-01-02T03:04:05Z"
+	if got, want := Read(), (Info{Commit: "abc123", BuildTime: "2024-01-02T03:04:05Z", GoVersion: runtime.Version()}); got != want {
+		t.Errorf("expected the link-time values %+v, got %+v", want, got)
+	}
+}
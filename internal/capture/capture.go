@@ -0,0 +1,99 @@
+// Package capture wraps http.ResponseWriter to record what a handler sent
+// without hiding the optional interfaces of the underlying writer.
+package capture
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// Writer records the status code and body size of a response. It passes
+// flushing and hijacking through and implements Unwrap, so
+// http.ResponseController reaches the underlying writer.
+type Writer struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+// Wrap returns a Writer recording the response written to w. If w already
+// is a *Writer it is returned as is, so nested middleware share one record.
+func Wrap(w http.ResponseWriter) *Writer {
+	if cw, ok := w.(*Writer); ok {
+		return cw
+	}
+	return &Writer{ResponseWriter: w}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WriteHeader records the first final status code and passes it on.
+func (w *Writer) WriteHeader(code int) {
+	if w.status == 0 && code >= http.StatusOK {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write counts the bytes written, implying status 200 like net/http does.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err //nolint:wrapcheck // transparent wrapper
+}
+
+// Flush implements http.Flusher. Writers that can't flush are ignored, as
+// http.Flusher has no way to report it.
+func (w *Writer) Flush() {
+	_ = w.FlushError() //nolint:errcheck // see above
+}
+
+// FlushError flushes the underlying writer, implying status 200.
+// It is what http.ResponseController calls.
+func (w *Writer) FlushError() error {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush() //nolint:wrapcheck // transparent wrapper
+}
+
+// Hijack implements http.Hijacker if the underlying writer supports it.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err //nolint:wrapcheck // transparent wrapper
+}
+
+// Written reports whether the status line has been sent.
+func (w *Writer) Written() bool {
+	return w.status != 0
+}
+
+// Status returns the status code sent, or 200, which net/http sends for
+// handlers that write nothing, if none was sent yet.
+func (w *Writer) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Bytes returns the number of body bytes written.
+func (w *Writer) Bytes() int64 {
+	return w.bytes
+}
+
+// Hijacked reports whether the connection was taken over by the handler.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
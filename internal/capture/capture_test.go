@@ -0,0 +1,88 @@
+package capture
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := Wrap(rec)
+	if Wrap(w) != w {
+		t.Error("expected wrapping a Writer to return it")
+	}
+	if w.Written() || w.Status() != http.StatusOK {
+		t.Errorf("expected an untouched writer to report 200, got %v", w.Status())
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusInternalServerError)
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		t.Errorf("expected flushing through ResponseController, got %v", err)
+	}
+	if !rec.Flushed {
+		t.Error("expected the underlying writer to be flushed")
+	}
+	if w.Status() != http.StatusCreated || w.Bytes() != 5 || !w.Written() {
+		t.Errorf("expected 201 and 5 bytes, got %v and %v", w.Status(), w.Bytes())
+	}
+	if w.Unwrap() != rec {
+		t.Error("expected Unwrap to return the underlying writer")
+	}
+
+	if _, _, err := w.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported from a recorder, got %v", err)
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, nil, nil
+}
+
+func TestHijack(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	w := Wrap(hijackRecorder{httptest.NewRecorder(), server})
+	conn, _, err := w.Hijack()
+	if err != nil || conn != server || !w.Hijacked() {
+		t.Errorf("expected the connection of the underlying writer, got %v, %v", conn, err)
+	}
+}
+
+func TestFlushImpliesOK(t *testing.T) {
+	w := Wrap(httptest.NewRecorder())
+	w.Flush()
+	if !w.Written() || w.Status() != http.StatusOK {
+		t.Errorf("expected a flush to send 200, got %v", w.Status())
+	}
+}
+
+type discard struct{ header http.Header }
+
+func (d discard) Header() http.Header       { return d.header }
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+func (discard) WriteHeader(int)             {}
+
+func TestInformationalStatusIsNotFinal(t *testing.T) {
+	w := Wrap(discard{http.Header{}})
+	w.WriteHeader(http.StatusEarlyHints)
+	if w.Written() {
+		t.Error("expected 103 not to count as the response status")
+	}
+	w.WriteHeader(http.StatusNoContent)
+	if w.Status() != http.StatusNoContent {
+		t.Errorf("expected 204, got %v", w.Status())
+	}
+}
@@ -0,0 +1,455 @@
+// Package config loads the application configuration.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sber_test/internal/auth"
+	"sber_test/internal/logging"
+	"sber_test/internal/tenant"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the names of environment variables overriding the
+// configuration. The rest of the name is the upper-cased YAML path joined
+// with underscores, e.g. SBER_CACHE_MAX_ITEMS for cache.max_items.
+const EnvPrefix = "SBER_"
+
+// ID schemes for Cache.IDs.
+const (
+	IDsSequential  = "sequential"
+	IDsTimeOrdered = "time_ordered"
+)
+
+// Span exporters for Trace.Exporter.
+const (
+	// TraceNone generates and propagates trace IDs but exports nothing.
+	TraceNone   = "none"
+	TraceStdout = "stdout"
+	TraceFile   = "file"
+)
+
+// Log formats for Log.Format.
+const (
+	LogText = logging.FormatText
+	LogJSON = logging.FormatJSON
+	// LogConsole keeps the access line format mandated by the task spec.
+	LogConsole = logging.FormatConsole
+)
+
+// Config holds every tunable of the application.
+type Config struct {
+	// Port is the TCP port the HTTP server listens on.
+	Port int `yaml:"port"`
+	// ReadTimeout, WriteTimeout and IdleTimeout configure http.Server.
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// DrainDelay is how long /readyz fails before the server stops taking
+	// requests, so load balancers notice first. It counts towards
+	// ShutdownTimeout.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// ProgramsFile is the path of the program catalogue.
+	ProgramsFile string `yaml:"programs_file"`
+
+	Cache  Cache  `yaml:"cache"`
+	Batch  Batch  `yaml:"batch"`
+	Import Import `yaml:"import"`
+	Log    Log    `yaml:"log"`
+	Trace  Trace  `yaml:"trace"`
+	Auth   Auth   `yaml:"auth"`
+
+	Tenancy     Tenancy     `yaml:"tenancy"`
+	RateLimit   RateLimit   `yaml:"rate_limit"`
+	Idempotency Idempotency `yaml:"idempotency"`
+}
+
+// Cache configures the calculation cache.
+type Cache struct {
+	// MaxItems bounds the number of cached calculations of every tenant;
+	// the oldest are evicted first. Zero means unlimited.
+	MaxItems int `yaml:"max_items"`
+	// IDs is the ID scheme, IDsSequential or IDsTimeOrdered.
+	IDs string `yaml:"ids"`
+}
+
+// Batch configures POST /execute/batch.
+type Batch struct {
+	MaxSize int `yaml:"max_size"`
+	Workers int `yaml:"workers"`
+}
+
+// Import configures POST /import.
+type Import struct {
+	// MaxRows is the maximum number of rows of an uploaded spreadsheet.
+	MaxRows int `yaml:"max_rows"`
+}
+
+// Log configures logging.
+type Log struct {
+	// Format is LogText, LogJSON or LogConsole.
+	Format string `yaml:"format"`
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string `yaml:"level"`
+}
+
+// Trace configures tracing.
+type Trace struct {
+	// Exporter is where finished spans go, as OTLP/JSON lines readable by
+	// the OpenTelemetry Collector's otlpjsonfile receiver: TraceNone,
+	// TraceStdout or TraceFile.
+	Exporter string `yaml:"exporter"`
+	// File is the path spans are appended to with TraceFile.
+	File string `yaml:"file"`
+	// SampleRatio is the fraction of new traces exported, from 0 to 1.
+	// Traces continued from a traceparent header keep the caller's choice.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// Auth configures client authentication.
+type Auth struct {
+	// Enabled requires credentials on every API route except the OpenAPI
+	// documents. When it is off, every request has every scope.
+	Enabled bool     `yaml:"enabled"`
+	APIKeys []APIKey `yaml:"api_keys"`
+	JWT     JWT      `yaml:"jwt"`
+}
+
+// APIKey is a static key sent in the X-API-Key header.
+type APIKey struct {
+	ClientID string `yaml:"client_id"`
+	// Tenant is the tenant the client acts for, by default its client ID.
+	Tenant string   `yaml:"tenant"`
+	Key    string   `yaml:"key"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// JWT configures bearer tokens. They are accepted if at least one key is
+// configured.
+type JWT struct {
+	// Issuer and Audience, if set, must match the iss and aud claims.
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// Leeway tolerates clock skew when checking exp and nbf.
+	Leeway time.Duration `yaml:"leeway"`
+	// JWKSFiles are JSON Web Key Sets with verification keys.
+	JWKSFiles []string `yaml:"jwks_files"`
+	Keys      []JWTKey `yaml:"keys"`
+}
+
+// JWTKey is a verification key: an HMAC secret or a PEM public key file.
+type JWTKey struct {
+	// ID is matched against the kid header of tokens.
+	ID            string `yaml:"id"`
+	Secret        string `yaml:"secret"`
+	PublicKeyFile string `yaml:"public_key_file"`
+}
+
+// Tenancy configures the isolation of tenants. Every tenant has its own
+// cached calculations; a client acts for the tenant of its API key or JWT.
+type Tenancy struct {
+	// TrustHeader lets the X-Tenant-ID header choose the tenant of clients
+	// whose credentials carry none. Enable it only behind a proxy that sets
+	// the header itself.
+	TrustHeader bool `yaml:"trust_header"`
+	// MaxTenants bounds the number of tenants whose calculations are kept;
+	// the least recently active one is dropped to make room. Zero means
+	// unlimited.
+	MaxTenants int `yaml:"max_tenants"`
+	// IdleTimeout drops the calculations of tenants inactive for that long.
+	// Zero keeps them.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// Tenants override the defaults per tenant ID.
+	Tenants map[string]Tenant `yaml:"tenants"`
+}
+
+// Tenant overrides the defaults for one tenant.
+type Tenant struct {
+	// RetainItems is how many calculations of the tenant are kept. It is a
+	// retention size, not a quota: the oldest calculations are evicted to
+	// make room and new ones are never refused. Zero means cache.max_items.
+	RetainItems int `yaml:"retain_items"`
+	// ProgramsFile is the program catalogue of the tenant. Empty means
+	// programs_file.
+	ProgramsFile string `yaml:"programs_file"`
+}
+
+// RateLimit configures per-client rate limits and load shedding.
+type RateLimit struct {
+	// Routes limits every client per route, keyed by the /v1 or /v2 path,
+	// e.g. "/v1/execute"; "*" applies to the routes not listed. Clients are
+	// told apart by API key or JWT, or else by IP. Routes not covered are
+	// unlimited.
+	Routes map[string]RouteLimit `yaml:"routes"`
+	// MaxClients bounds the clients tracked per route; the least recently
+	// seen is forgotten first and starts over with a full bucket. Zero means
+	// unlimited.
+	MaxClients int `yaml:"max_clients"`
+	// MaxInFlight bounds the API requests served at once; the rest are
+	// rejected with 503. Zero means unlimited.
+	MaxInFlight int `yaml:"max_in_flight"`
+}
+
+// RouteLimit is the token bucket of a client on a route.
+type RouteLimit struct {
+	// Rate is the number of requests per second.
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of requests allowed at once.
+	Burst int `yaml:"burst"`
+}
+
+// Idempotency configures the Idempotency-Key header of POST /execute.
+type Idempotency struct {
+	// TTL is how long first responses are replayed. Zero ignores the header.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxKeys bounds the number of keys remembered; the oldest are evicted
+	// first. Zero means unlimited.
+	MaxKeys int `yaml:"max_keys"`
+	// MaxBodySize is the largest response body kept, in bytes; larger
+	// responses aren't replayed. Zero means unlimited.
+	MaxBodySize int `yaml:"max_body_size"`
+}
+
+// SlogLevel returns Level as a slog.Level.
+func (l Log) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(l.Level))
+	return level, err //nolint:wrapcheck // reported by Validate
+}
+
+// Default returns the configuration used for settings that are not set.
+func Default() Config {
+	return Config{
+		Port:            8080,
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		IdleTimeout:     30 * time.Second,
+		ShutdownTimeout: 15 * time.Second,
+		DrainDelay:      5 * time.Second,
+		ProgramsFile:    "programs.json",
+		Cache:           Cache{IDs: IDsSequential},
+		Batch:           Batch{MaxSize: 100, Workers: 8},
+		Import:          Import{MaxRows: 1000},
+		Log:             Log{Format: LogText, Level: "info"},
+		Trace:           Trace{Exporter: TraceNone, File: "traces.jsonl", SampleRatio: 1},
+		Auth:            Auth{JWT: JWT{Leeway: 30 * time.Second}},
+		Tenancy:         Tenancy{MaxTenants: 1000, IdleTimeout: time.Hour},
+		RateLimit:       RateLimit{MaxClients: 10000},
+		Idempotency:     Idempotency{TTL: 24 * time.Hour, MaxKeys: 10000, MaxBodySize: 64 << 10},
+	}
+}
+
+// Load returns the defaults overridden by the YAML file at path, unless path
+// is empty, and then by the environment variables found by lookupEnv. Unknown
+// keys in the file are errors, so typos don't go unnoticed. The result is
+// validated.
+func Load(path string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("read config: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem(), EnvPrefix, lookupEnv); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv sets the fields of the struct v from the environment variables
+// named after their YAML keys.
+func applyEnv(v reflect.Value, prefix string, lookupEnv func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(key)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name+"_", lookupEnv); err != nil {
+				return err
+			}
+			continue
+		}
+		raw, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, raw); err != nil {
+			return fmt.Errorf("%s: invalid value %q: %w", name, raw, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err //nolint:wrapcheck // wrapped by the caller
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported setting type %s", field.Type())
+		}
+		// Lists of strings are comma-separated.
+		field.Set(reflect.ValueOf(strings.Split(raw, ",")))
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err //nolint:wrapcheck // wrapped by the caller
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err //nolint:wrapcheck // wrapped by the caller
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err //nolint:wrapcheck // wrapped by the caller
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate reports every invalid setting, naming it by its YAML path.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+		}
+	}
+	check(c.Port > 0 && c.Port <= 65535, "port", "must be from 1 to 65535, got %d", c.Port)
+	check(c.ReadTimeout > 0, "read_timeout", "must be positive, got %s", c.ReadTimeout)
+	check(c.WriteTimeout > 0, "write_timeout", "must be positive, got %s", c.WriteTimeout)
+	check(c.IdleTimeout > 0, "idle_timeout", "must be positive, got %s", c.IdleTimeout)
+	check(c.ShutdownTimeout > 0, "shutdown_timeout", "must be positive, got %s", c.ShutdownTimeout)
+	check(c.DrainDelay >= 0 && c.DrainDelay < c.ShutdownTimeout, "drain_delay",
+		"must be from 0 to less than shutdown_timeout, got %s", c.DrainDelay)
+	check(c.ProgramsFile != "", "programs_file", "must not be empty")
+	check(c.Cache.MaxItems >= 0, "cache.max_items", "must not be negative, got %d", c.Cache.MaxItems)
+	check(c.Cache.IDs == IDsSequential || c.Cache.IDs == IDsTimeOrdered,
+		"cache.ids", "must be %q or %q, got %q", IDsSequential, IDsTimeOrdered, c.Cache.IDs)
+	check(c.Batch.MaxSize > 0, "batch.max_size", "must be positive, got %d", c.Batch.MaxSize)
+	check(c.Batch.Workers > 0, "batch.workers", "must be positive, got %d", c.Batch.Workers)
+	check(c.Import.MaxRows > 0, "import.max_rows", "must be positive, got %d", c.Import.MaxRows)
+	check(c.Log.Format == LogText || c.Log.Format == LogJSON || c.Log.Format == LogConsole,
+		"log.format", "must be %q, %q or %q, got %q", LogText, LogJSON, LogConsole, c.Log.Format)
+	_, err := c.Log.SlogLevel()
+	check(err == nil, "log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	check(c.Trace.Exporter == TraceNone || c.Trace.Exporter == TraceStdout || c.Trace.Exporter == TraceFile,
+		"trace.exporter", "must be %q, %q or %q, got %q", TraceNone, TraceStdout, TraceFile, c.Trace.Exporter)
+	check(c.Trace.Exporter != TraceFile || c.Trace.File != "", "trace.file", "must not be empty with the file exporter")
+	check(c.Trace.SampleRatio >= 0 && c.Trace.SampleRatio <= 1, "trace.sample_ratio", "must be from 0 to 1, got %g", c.Trace.SampleRatio)
+	errs = append(errs, c.Auth.validate()...)
+	check(c.Tenancy.MaxTenants >= 0, "tenancy.max_tenants", "must not be negative, got %d", c.Tenancy.MaxTenants)
+	check(c.Tenancy.IdleTimeout >= 0, "tenancy.idle_timeout", "must not be negative, got %s", c.Tenancy.IdleTimeout)
+	ids := make([]string, 0, len(c.Tenancy.Tenants))
+	for id := range c.Tenancy.Tenants {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		t := c.Tenancy.Tenants[id]
+		key := "tenancy.tenants." + id
+		check(tenant.Valid(id), key, "invalid tenant ID, want 1 to 64 letters, digits, \"-\", \"_\" or \".\"")
+		check(t.RetainItems >= 0, key+".retain_items", "must not be negative, got %d", t.RetainItems)
+	}
+	routes := make([]string, 0, len(c.RateLimit.Routes))
+	for route := range c.RateLimit.Routes {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+	for _, route := range routes {
+		l := c.RateLimit.Routes[route]
+		key := "rate_limit.routes." + route
+		check(route == "*" || strings.HasPrefix(route, "/v1/") || strings.HasPrefix(route, "/v2/"),
+			key, `must be "*" or a path starting with /v1/ or /v2/`)
+		check(l.Rate > 0, key+".rate", "must be positive, got %g", l.Rate)
+		check(l.Burst > 0, key+".burst", "must be positive, got %d", l.Burst)
+	}
+	check(c.Idempotency.TTL >= 0, "idempotency.ttl", "must not be negative, got %s", c.Idempotency.TTL)
+	check(c.Idempotency.MaxKeys >= 0, "idempotency.max_keys", "must not be negative, got %d", c.Idempotency.MaxKeys)
+	check(c.Idempotency.MaxBodySize >= 0, "idempotency.max_body_size",
+		"must not be negative, got %d", c.Idempotency.MaxBodySize)
+	check(c.RateLimit.MaxClients >= 0, "rate_limit.max_clients", "must not be negative, got %d", c.RateLimit.MaxClients)
+	check(c.RateLimit.MaxInFlight >= 0, "rate_limit.max_in_flight", "must not be negative, got %d", c.RateLimit.MaxInFlight)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// minSecretLen is the shortest HMAC secret accepted, 256 bits.
+const minSecretLen = 32
+
+func (a Auth) validate() []error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+		}
+	}
+	if a.Enabled {
+		check(len(a.APIKeys) > 0 || len(a.JWT.Keys) > 0 || len(a.JWT.JWKSFiles) > 0,
+			"auth", "needs api_keys or jwt keys when enabled")
+	}
+	keys := make(map[string]bool, len(a.APIKeys))
+	for i, k := range a.APIKeys {
+		key := fmt.Sprintf("auth.api_keys[%d]", i)
+		check(k.ClientID != "", key+".client_id", "must not be empty")
+		check(k.Key != "", key+".key", "must not be empty")
+		check(!keys[k.Key], key+".key", "is used twice")
+		check(k.Tenant == "" || tenant.Valid(k.Tenant), key+".tenant",
+			"invalid tenant ID %q, want 1 to 64 letters, digits, \"-\", \"_\" or \".\"", k.Tenant)
+		check(k.Tenant != "" || k.ClientID == "" || tenant.Valid(k.ClientID), key+".tenant",
+			"must be set, as client_id %q is no valid tenant ID", k.ClientID)
+		keys[k.Key] = true
+		for _, s := range k.Scopes {
+			check(slices.Contains(auth.Scopes, s), key+".scopes", "unknown scope %q, want one of %v", s, auth.Scopes)
+		}
+	}
+	check(a.JWT.Leeway >= 0, "auth.jwt.leeway", "must not be negative, got %s", a.JWT.Leeway)
+	for i, k := range a.JWT.Keys {
+		key := fmt.Sprintf("auth.jwt.keys[%d]", i)
+		check((k.Secret == "") != (k.PublicKeyFile == ""), key, "needs exactly one of secret and public_key_file")
+		check(k.Secret == "" || len(k.Secret) >= minSecretLen, key+".secret", "must be at least %d bytes", minSecretLen)
+	}
+	return errs
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	cfg, err := Load("", env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("expected the defaults, got %+v", cfg)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, "port: 9090\nread_timeout: 3s\ncache:\n  max_items: 10\nlog:\n  format: json\n"+
+		"tenancy:\n  tenants:\n    bank-a: {retain_items: 5, programs_file: bank-a.json}\n")
+	cfg, err := Load(path, env(map[string]string{
+		"SBER_PORT":                     "9191",
+		"SBER_CACHE_IDS":                "time_ordered",
+		"SBER_SHUTDOWN_TIMEOUT":         "1m",
+		"SBER_TRACE_EXPORTER":           "stdout",
+		"SBER_TRACE_SAMPLE_RATIO":       "0.25",
+		"SBER_AUTH_JWT_JWKS_FILES":      "a.json,b.json",
+		"SBER_TENANCY_TRUST_HEADER":     "true",
+		"SBER_RATE_LIMIT_MAX_IN_FLIGHT": "64",
+		"SBER_IDEMPOTENCY_TTL":          "1h",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Port = 9191
+	want.ReadTimeout = 3 * time.Second
+	want.ShutdownTimeout = time.Minute
+	want.Cache = Cache{MaxItems: 10, IDs: IDsTimeOrdered}
+	want.Log.Format = LogJSON
+	want.Trace.Exporter = TraceStdout
+	want.Trace.SampleRatio = 0.25
+	want.Auth.JWT.JWKSFiles = []string{"a.json", "b.json"}
+	want.RateLimit.MaxInFlight = 64
+	want.Idempotency.TTL = time.Hour
+	want.Tenancy.TrustHeader = true
+	want.Tenancy.Tenants = map[string]Tenant{"bank-a": {RetainItems: 5, ProgramsFile: "bank-a.json"}}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr []string
+	}{
+		{
+			name:    "unknown key",
+			file:    "prot: 8080\n",
+			wantErr: []string{"field prot not found"},
+		},
+		{
+			name:    "invalid env value",
+			env:     map[string]string{"SBER_BATCH_WORKERS": "many"},
+			wantErr: []string{"SBER_BATCH_WORKERS", `"many"`},
+		},
+		{
+			name: "every invalid setting is reported",
+			file: "port: 0\nwrite_timeout: -1s\ndrain_delay: 1h\ncache:\n  ids: random\n",
+			env: map[string]string{"SBER_LOG_FORMAT": "xml", "SBER_LOG_LEVEL": "loud", "SBER_IDEMPOTENCY_TTL": "-1s",
+				"SBER_IDEMPOTENCY_MAX_KEYS": "-1",
+				"SBER_TRACE_EXPORTER":       "file", "SBER_TRACE_FILE": "", "SBER_TRACE_SAMPLE_RATIO": "1.5"},
+			wantErr: []string{
+				"port: must be from 1 to 65535, got 0",
+				"write_timeout: must be positive",
+				"drain_delay: must be from 0 to less than shutdown_timeout, got 1h0m0s",
+				`cache.ids: must be "sequential" or "time_ordered", got "random"`,
+				`log.format: must be "text", "json" or "console", got "xml"`,
+				`log.level: must be debug, info, warn or error, got "loud"`,
+				"trace.file: must not be empty with the file exporter",
+				"trace.sample_ratio: must be from 0 to 1, got 1.5",
+				"idempotency.ttl: must not be negative, got -1s",
+				"idempotency.max_keys: must not be negative, got -1",
+			},
+		},
+		{
+			name: "auth",
+			file: `auth:
+  enabled: true
+  api_keys:
+    - {client_id: a, key: k, scopes: [calc:execute, cache:write]}
+    - {key: k}
+    - {client_id: b, key: kb, tenant: bank b}
+    - {client_id: bank c, key: kc}
+  jwt:
+    keys:
+      - {id: short, secret: tooshort}
+      - {id: both, secret: 0123456789abcdef0123456789abcdef, public_key_file: key.pem}
+`,
+			wantErr: []string{
+				`auth.api_keys[0].scopes: unknown scope "cache:write"`,
+				"auth.api_keys[1].client_id: must not be empty",
+				"auth.api_keys[1].key: is used twice",
+				`auth.api_keys[2].tenant: invalid tenant ID "bank b"`,
+				`auth.api_keys[3].tenant: must be set, as client_id "bank c" is no valid tenant ID`,
+				"auth.jwt.keys[0].secret: must be at least 32 bytes",
+				"auth.jwt.keys[1]: needs exactly one of secret and public_key_file",
+			},
+		},
+		{
+			name: "tenancy",
+			file: `tenancy:
+  max_tenants: -1
+  idle_timeout: -1s
+  tenants:
+    bank a: {}
+    bank-b: {retain_items: -1}
+`,
+			wantErr: []string{
+				"tenancy.max_tenants: must not be negative, got -1",
+				"tenancy.idle_timeout: must not be negative, got -1s",
+				"tenancy.tenants.bank a: invalid tenant ID",
+				"tenancy.tenants.bank-b.retain_items: must not be negative, got -1",
+			},
+		},
+		{
+			name: "rate limits",
+			file: `rate_limit:
+  max_clients: -1
+  max_in_flight: -1
+  routes:
+    /execute: {rate: 1, burst: 1}
+    /v1/execute: {rate: 0, burst: 0}
+`,
+			wantErr: []string{
+				`rate_limit.routes./execute: must be "*" or a path starting with /v1/ or /v2/`,
+				"rate_limit.routes./v1/execute.rate: must be positive, got 0",
+				"rate_limit.routes./v1/execute.burst: must be positive, got 0",
+				"rate_limit.max_clients: must not be negative, got -1",
+				"rate_limit.max_in_flight: must not be negative, got -1",
+			},
+		},
+		{
+			name:    "auth without credentials",
+			env:     map[string]string{"SBER_AUTH_ENABLED": "true"},
+			wantErr: []string{"auth: needs api_keys or jwt keys when enabled"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.file != "" {
+				path = writeConfig(t, tt.file)
+			}
+			_, err := Load(path, env(tt.env))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %q in %q", want, err)
+				}
+			}
+		})
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yml"), env(nil)); err == nil || !strings.Contains(err.Error(), "read config") {
+		t.Errorf("expected a read error for a missing file, got %v", err)
+	}
+}
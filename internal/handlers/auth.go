@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"sber_test/internal/auth"
+	"sber_test/internal/metrics"
+	"sber_test/internal/tracing"
+)
+
+var authFailures = metrics.Default.NewCounter("calculator_auth_failures_total",
+	"Requests rejected for missing or invalid credentials or scopes, by error code.", "code")
+
+// Authenticate is a middleware putting the principal of the request's
+// credentials into its context. Requests without credentials pass through
+// without a principal, so public routes keep working and RequireScope turns
+// them away from protected ones; invalid credentials are rejected with 401
+// right away. With a nil a authentication is disabled and every request
+// gets auth.Anonymous, which has every scope.
+func Authenticate(a *auth.Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if a == nil {
+				next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), auth.Anonymous)))
+				return
+			}
+			p, err := a.Authenticate(r)
+			switch {
+			case errors.Is(err, auth.ErrNoCredentials):
+				next.ServeHTTP(w, r)
+				return
+			case err != nil:
+				slog.InfoContext(r.Context(), "authentication failed", "err", err)
+				unauthorized(w, `Bearer error="invalid_token"`, "invalid credentials")
+				return
+			}
+			tracing.SpanFromContext(r.Context()).SetAttributes(tracing.String("client_id", p.ClientID))
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+// RequireScope is a middleware letting through only requests whose
+// principal has scope: requests without one get 401, those lacking the
+// scope 403.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := auth.FromContext(r.Context())
+			if !ok {
+				unauthorized(w, "Bearer", "authentication required")
+				return
+			}
+			if !p.Has(scope) {
+				authFailures.Inc(CodeForbidden)
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+				writeError(w, http.StatusForbidden, ErrorResponse{
+					Error:   "insufficient scope",
+					Code:    CodeForbidden,
+					Details: map[string]string{"required_scope": scope},
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// unauthorized answers 401 with the given WWW-Authenticate challenge.
+func unauthorized(w http.ResponseWriter, challenge, msg string) {
+	authFailures.Inc(CodeUnauthorized)
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeError(w, http.StatusUnauthorized, ErrorResponse{Error: msg, Code: CodeUnauthorized})
+}
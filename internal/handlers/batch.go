@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"sber_test/internal/service"
+)
+
+// BatchLimits bounds POST /execute/batch.
+type BatchLimits struct {
+	// MaxSize is the maximum number of requests in one batch.
+	MaxSize int
+	// Workers is the number of requests evaluated concurrently.
+	Workers int
+}
+
+// DefaultBatchLimits are used for limits left at zero.
+var DefaultBatchLimits = BatchLimits{MaxSize: 100, Workers: 8}
+
+func (l BatchLimits) withDefaults() BatchLimits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultBatchLimits.MaxSize
+	}
+	if l.Workers <= 0 {
+		l.Workers = DefaultBatchLimits.Workers
+	}
+	return l
+}
+
+// batchItem is the outcome of one request of a batch.
+type batchItem struct {
+	Details any                      `json:"details,omitempty"`
+	Result  *service.ExecuteResponse `json:"result,omitempty"`
+	ID      service.ID               `json:"id,omitempty"`
+	Error   string                   `json:"error,omitempty"`
+	Code    string                   `json:"code,omitempty"`
+	Field   string                   `json:"field,omitempty"`
+	Index   int                      `json:"index"`
+	Status  int                      `json:"status"`
+	Cached  bool                     `json:"cached,omitempty"`
+}
+
+type batchResponse struct {
+	Results   []batchItem `json:"results"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+}
+
+// ExecuteBatch evaluates a JSON array of execute requests concurrently and
+// returns the results in input order, each with its own status and error.
+// With "Accept: application/x-ndjson" results are streamed one per line as
+// they complete instead. Results are written to the cache unless the query
+// has store=false.
+func ExecuteBatch(svc *service.Service, limits BatchLimits) http.HandlerFunc {
+	limits = limits.withDefaults()
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := true
+		if v := r.URL.Query().Get("store"); v != "" {
+			var err error
+			if store, err = strconv.ParseBool(v); err != nil {
+				writeError(w, http.StatusBadRequest, ErrorResponse{Error: "invalid store flag", Code: CodeInvalidRequest, Field: "store"})
+				return
+			}
+		}
+
+		data, ok := readBody(w, r)
+		if !ok {
+			return
+		}
+		var reqs []json.RawMessage
+		if err := json.Unmarshal(data, &reqs); err != nil {
+			writeError(w, http.StatusBadRequest, ErrorResponse{Error: service.ErrMalformedRequest.Error(), Code: CodeInvalidRequest})
+			return
+		}
+		if len(reqs) == 0 {
+			writeError(w, http.StatusBadRequest, ErrorResponse{Error: "empty batch", Code: CodeEmptyBatch})
+			return
+		}
+		if len(reqs) > limits.MaxSize {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error:   "batch too large",
+				Code:    CodeBatchTooLarge,
+				Details: map[string]int{"max_size": limits.MaxSize, "size": len(reqs)},
+			})
+			return
+		}
+
+		if wantsNDJSON(r) {
+			out := newNDJSONWriter(w)
+			runBatch(r.Context(), svc, reqs, store, limits.Workers, func(item batchItem) {
+				_ = out.Write(item) //nolint:errcheck // a failed write means the client is gone
+			})
+			return
+		}
+
+		out := batchResponse{Results: make([]batchItem, len(reqs))}
+		runBatch(r.Context(), svc, reqs, store, limits.Workers, func(item batchItem) {
+			out.Results[item.Index] = item
+			if item.Status == http.StatusOK {
+				out.Succeeded++
+			} else {
+				out.Failed++
+			}
+		})
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// runBatch evaluates reqs with a pool of workers and passes every result to
+// emit as soon as it is ready, so results arrive in completion order. emit is
+// never called concurrently. Requests not started before ctx is cancelled are
+// reported as failed.
+func runBatch(ctx context.Context, svc *service.Service, reqs []json.RawMessage, store bool, workers int, emit func(batchItem)) {
+	jobs := make(chan int)
+	done := make(chan batchItem)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(reqs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				done <- evaluate(ctx, svc, i, reqs[i], store)
+			}
+		}()
+	}
+
+	next := 0
+	go func() {
+		defer func() {
+			close(jobs)
+			wg.Wait()
+			close(done)
+		}()
+		for ; next < len(reqs); next++ {
+			select {
+			case jobs <- next:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for item := range done {
+		emit(item)
+	}
+
+	for i := next; i < len(reqs); i++ {
+		emit(batchItem{Index: i, Status: http.StatusServiceUnavailable, Error: "request cancelled", Code: CodeCancelled})
+	}
+}
+
+// evaluate calculates one batch request. It runs on a worker goroutine that
+// Recoverer doesn't cover, so a panic is reported as a failed item.
+func evaluate(ctx context.Context, svc *service.Service, index int, data json.RawMessage, store bool) (item batchItem) {
+	defer func() {
+		if p := recover(); p != nil {
+			reportPanic(ctx, p)
+			item = batchItem{Index: index, Status: http.StatusInternalServerError, Error: "internal error", Code: CodeInternal}
+		}
+	}()
+	req, err := service.DecodeRequest(data)
+	if err != nil {
+		return failedItem(ctx, index, err)
+	}
+	var res service.Result
+	if store {
+		res, err = svc.Calculate(ctx, req)
+	} else {
+		res, err = svc.Preview(ctx, req)
+	}
+	if err != nil {
+		return failedItem(ctx, index, err)
+	}
+	return batchItem{
+		Index:  index,
+		Status: http.StatusOK,
+		Result: &res.Response,
+		ID:     res.ID,
+		Cached: res.Cached,
+	}
+}
+
+func failedItem(ctx context.Context, index int, err error) batchItem {
+	status, body := classify(ctx, err)
+	return batchItem{
+		Index:   index,
+		Status:  status,
+		Error:   body.Error,
+		Code:    body.Code,
+		Field:   body.Field,
+		Details: body.Details,
+	}
+}
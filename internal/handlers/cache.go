@@ -1,23 +1,59 @@
 package handlers
 
 import (
-	"encoding/json"
+	"log/slog"
 	"net/http"
 
 	"sber_test/internal/service"
 )
 
-// GetCache returns the cached items in JSON format.
+// GetCache returns the cached items in JSON format. With
+// "Accept: application/x-ndjson" the items are streamed one per line.
 func GetCache(svc *service.Service) http.HandlerFunc {
-	return func(w http.ResponseWriter, _ *http.Request) {
-		all := svc.GetAll()
+	return getCache(svc, func(_ *http.Request, item service.CacheItem) (any, error) {
+		return item, nil
+	})
+}
+
+// getCache responds with the cached items, each converted by render.
+func getCache(svc *service.Service, render func(*http.Request, service.CacheItem) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all := svc.GetAll(r.Context())
 		if len(all) == 0 {
-			http.Error(w, `{"error":"empty cache"}`, http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, ErrorResponse{Error: "empty cache", Code: CodeEmptyCache})
+			return
+		}
+		if wantsNDJSON(r) {
+			streamCache(w, r, all, render)
+			return
+		}
+		out := make([]any, len(all))
+		for i, item := range all {
+			v, err := render(r, item)
+			if err != nil {
+				writeServiceError(w, r, err)
+				return
+			}
+			out[i] = v
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// streamCache writes items until they run out, a write fails or the client
+// goes away.
+func streamCache(w http.ResponseWriter, r *http.Request, items []service.CacheItem, render func(*http.Request, service.CacheItem) (any, error)) {
+	out := newNDJSONWriter(w)
+	for _, item := range items {
+		if r.Context().Err() != nil {
+			return
+		}
+		v, err := render(r, item)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "render cache item", "id", item.ID, "err", err)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(all); err != nil {
-			http.Error(w, `{"error":"failed to encode data"}`, http.StatusInternalServerError)
+		if err := out.Write(v); err != nil {
 			return
 		}
 	}
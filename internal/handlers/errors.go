@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"sber_test/internal/service"
+)
+
+// ErrorResponse is the body of every error response. Error keeps the exact
+// messages required by the spec; Code is a stable machine-readable
+// identifier; Field is a JSON pointer to the offending request field.
+// RequestID is set on internal errors so they can be traced in the logs.
+type ErrorResponse struct {
+	Details   any    `json:"details,omitempty"`
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Error codes.
+const (
+	CodeInvalidRequest          = "invalid_request"
+	CodeValidationFailed        = "validation_failed"
+	CodeRequestTooLarge         = "request_too_large"
+	CodeUnknownProgram          = "unknown_program"
+	CodeChooseProgram           = "choose_program"
+	CodeChooseOnlyOneProgram    = "choose_only_one_program"
+	CodeInitialPaymentLow       = "initial_payment_low"
+	CodeFirstPaymentExceedsLoan = "first_payment_exceeds_loan"
+	CodeEmptyCache              = "empty_cache"
+	CodeUnsupportedFormat       = "unsupported_format"
+	CodeInvalidUpload           = "invalid_upload"
+	CodeImportTooLarge          = "import_too_large"
+	CodeEmptyBatch              = "empty_batch"
+	CodeBatchTooLarge           = "batch_too_large"
+	CodeCancelled               = "cancelled"
+	CodeCatalogueUnavailable    = "catalogue_unavailable"
+	CodeEncodingFailed          = "encoding_failed"
+	CodeInternal                = "internal_error"
+	CodeUnauthorized            = "unauthorized"
+	CodeForbidden               = "forbidden"
+	CodeInvalidTenant           = "invalid_tenant"
+	CodeRateLimited             = "rate_limited"
+	CodeOverloaded              = "overloaded"
+	CodeInvalidIdempotencyKey   = "invalid_idempotency_key"
+	CodeIdempotencyKeyReused    = "idempotency_key_reused"
+	CodeIdempotencyKeyInUse     = "idempotency_key_in_use"
+)
+
+const contentTypeJSON = "application/json"
+
+// writeJSON encodes v before writing anything, so an encoding failure can
+// still be reported as a proper error response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("encode response", "err", err)
+		writeError(w, http.StatusInternalServerError, ErrorResponse{
+			Error: "failed to encode data",
+			Code:  CodeEncodingFailed,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	_, _ = w.Write(append(data, '\n')) //nolint:errcheck // a failed write means the client is gone
+}
+
+// writeError writes body as a JSON error response.
+func writeError(w http.ResponseWriter, status int, body ErrorResponse) {
+	// ErrorResponse only holds strings and JSON-safe details, so Marshal can't fail.
+	data, _ := json.Marshal(body) //nolint:errcheck // see above
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", contentTypeJSON)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(data, '\n')) //nolint:errcheck // a failed write means the client is gone
+}
+
+// maxRequestSize caps JSON request bodies.
+const maxRequestSize = 1 << 20
+
+// readBody reads the request body, answering with an error response and
+// returning false when it is too large or can't be read.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err == nil {
+		return data, true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		requestFailures.Inc(CodeRequestTooLarge)
+		writeError(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:   "request body too large",
+			Code:    CodeRequestTooLarge,
+			Details: map[string]int64{"max_bytes": tooLarge.Limit},
+		})
+		return nil, false
+	}
+	writeError(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request", Code: CodeInvalidRequest})
+	return nil, false
+}
+
+// writeServiceError reports an error returned by the service.
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	status, body := classify(r.Context(), err)
+	writeError(w, status, body)
+}
+
+// classify maps an error returned by the service to its HTTP status and
+// response body, counting rejected requests by error code.
+func classify(ctx context.Context, err error) (int, ErrorResponse) {
+	status, body := classifyError(ctx, err)
+	if status < http.StatusInternalServerError {
+		requestFailures.Inc(body.Code)
+	}
+	return status, body
+}
+
+// classifyError does the mapping for classify. Request problems the spec
+// names keep status 400; requests that are well-formed but can't be
+// satisfied get 422; a missing or broken program catalogue is 503; anything
+// else is an internal error whose details are logged rather than sent to
+// the client.
+func classifyError(ctx context.Context, err error) (int, ErrorResponse) {
+	var (
+		invalid *service.ValidationError
+		unknown *service.UnknownProgramError
+	)
+	switch {
+	case errors.As(err, &invalid):
+		body := ErrorResponse{Error: service.ErrValidation.Error(), Code: CodeValidationFailed, Details: invalid.Fields}
+		if len(invalid.Fields) == 1 {
+			body.Field = invalid.Fields[0].Field
+		}
+		return http.StatusBadRequest, body
+	case errors.Is(err, service.ErrMalformedRequest):
+		return http.StatusBadRequest, ErrorResponse{Error: service.ErrMalformedRequest.Error(), Code: CodeInvalidRequest}
+	case errors.As(err, &unknown):
+		return http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+			Code:  CodeUnknownProgram,
+			Field: service.FieldPointer("program", unknown.Name),
+		}
+	case errors.Is(err, service.ErrChooseProgram):
+		return http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: CodeChooseProgram, Field: "/program"}
+	case errors.Is(err, service.ErrChooseOnlyOneProgram):
+		return http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: CodeChooseOnlyOneProgram, Field: "/program"}
+	case errors.Is(err, service.ErrInitialPaymentLow):
+		return http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: CodeInitialPaymentLow, Field: "/initial_payment"}
+	case errors.Is(err, service.ErrFirstPaymentExceedsLoan):
+		return http.StatusUnprocessableEntity, ErrorResponse{
+			Error: err.Error(),
+			Code:  CodeFirstPaymentExceedsLoan,
+			Field: "/initial_payment",
+		}
+	case errors.Is(err, service.ErrCatalogueUnavailable):
+		slog.ErrorContext(ctx, "program catalogue unavailable", "err", err)
+		return http.StatusServiceUnavailable, ErrorResponse{
+			Error: service.ErrCatalogueUnavailable.Error(),
+			Code:  CodeCatalogueUnavailable,
+		}
+	default:
+		slog.ErrorContext(ctx, "internal error", "err", err)
+		return http.StatusInternalServerError, ErrorResponse{Error: "internal error", Code: CodeInternal}
+	}
+}
@@ -1,36 +1,67 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"sber_test/internal/service"
+	"sber_test/internal/tracing"
+)
+
+// Headers describing how /execute was served.
+const (
+	// HeaderCache is "HIT" when an identical earlier calculation was reused, "MISS" otherwise.
+	HeaderCache = "X-Cache"
+	// HeaderCacheID is the ID of the cache item holding the result.
+	HeaderCacheID = "X-Cache-ID"
 )
 
 // Execute handles the loan calculation request and returns the response.
 func Execute(svc *service.Service) http.HandlerFunc {
+	return execute(svc, func(_ *http.Request, res service.Result) (any, error) {
+		return struct {
+			Result service.ExecuteResponse `json:"result"`
+		}{
+			Result: res.Response,
+		}, nil
+	})
+}
+
+// execute calculates the requested loan and responds with the body render
+// builds from the result.
+func execute(svc *service.Service, render func(*http.Request, service.Result) (any, error)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req service.ExecuteRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		_, span := tracing.Start(r.Context(), "decode")
+		data, ok := readBody(w, r)
+		if !ok {
+			span.End()
 			return
 		}
-
-		resp, _, err := svc.Execute(req)
+		req, err := service.DecodeRequest(data)
+		span.SetAttributes(tracing.Int("bytes", len(data)))
+		span.RecordError(err)
+		span.End()
 		if err != nil {
-			http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+			writeServiceError(w, r, err)
 			return
 		}
 
-		out := struct {
-			Result service.ExecuteResponse `json:"result"`
-		}{
-			Result: resp,
+		res, err := svc.Calculate(r.Context(), req)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(out); err != nil {
-			http.Error(w, `{"error":"failed to encode data"}`, http.StatusInternalServerError)
+
+		out, err := render(r, res)
+		if err != nil {
+			writeServiceError(w, r, err)
 			return
 		}
+		w.Header().Set(HeaderCacheID, string(res.ID))
+		if res.Cached {
+			w.Header().Set(HeaderCache, "HIT")
+		} else {
+			w.Header().Set(HeaderCache, "MISS")
+		}
+		writeJSON(w, http.StatusOK, out)
 	}
 }
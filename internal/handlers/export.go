@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sber_test/internal/service"
+	"sber_test/internal/xlsx"
+)
+
+var exportColumns = []string{
+	"id",
+	"object_cost",
+	"initial_payment",
+	"months",
+	"program",
+	"rate",
+	"loan_sum",
+	"monthly_payment",
+	"overpayment",
+	"last_payment_date",
+}
+
+var scheduleColumns = []string{
+	"id",
+	"number",
+	"date",
+	"payment",
+	"principal",
+	"interest",
+	"balance",
+}
+
+// ExportCache streams the cached items as CSV (?format=csv) or as an XLSX
+// workbook (?format=xlsx). With ?schedule=true the workbook also gets a
+// sheet with the monthly repayment schedule of every loan.
+func ExportCache(svc *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		withSchedule, _ := strconv.ParseBool(r.URL.Query().Get("schedule")) //nolint:errcheck // absent or invalid means false
+
+		all := svc.GetAll(r.Context())
+		var err error
+		switch format {
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="cache.csv"`)
+			err = writeCSV(w, all)
+		case "xlsx":
+			w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			w.Header().Set("Content-Disposition", `attachment; filename="cache.xlsx"`)
+			err = writeXLSX(w, all, withSchedule)
+		default:
+			writeError(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "unsupported format",
+				Code:    CodeUnsupportedFormat,
+				Details: map[string]any{"supported": []string{"csv", "xlsx"}},
+			})
+			return
+		}
+		if err != nil {
+			// The body is already partially written, so the status can't change.
+			slog.ErrorContext(r.Context(), "export cache", "format", format, "err", err)
+		}
+	}
+}
+
+func writeCSV(w http.ResponseWriter, items []service.CacheItem) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, item := range items {
+		a := item.Aggregates
+		p := item.Params
+		if err := cw.Write([]string{
+			string(item.ID),
+			formatFloat(p.ObjectCost),
+			formatFloat(p.InitialPayment),
+			strconv.Itoa(p.Months),
+			item.ProgramName(),
+			formatFloat(a.Rate),
+			formatFloat(a.LoanSum),
+			formatFloat(a.MonthlyPayment),
+			formatFloat(a.Overpayment),
+			a.LastPaymentDate,
+		}); err != nil {
+			return fmt.Errorf("write item %s: %w", item.ID, err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
+
+func writeXLSX(w http.ResponseWriter, items []service.CacheItem, withSchedule bool) error {
+	xw := xlsx.NewWriter(w)
+	sheet, err := xw.NewSheet("Calculations")
+	if err != nil {
+		return fmt.Errorf("create sheet: %w", err)
+	}
+	if err := sheet.WriteRow(headerCells(exportColumns)...); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	for _, item := range items {
+		if err := sheet.WriteRow(itemCells(item)...); err != nil {
+			return fmt.Errorf("write item %s: %w", item.ID, err)
+		}
+	}
+	if err := sheet.Close(); err != nil {
+		return fmt.Errorf("close sheet: %w", err)
+	}
+	if withSchedule {
+		if err := writeScheduleSheet(xw, items); err != nil {
+			return err
+		}
+	}
+	if err := xw.Close(); err != nil {
+		return fmt.Errorf("close workbook: %w", err)
+	}
+	return nil
+}
+
+func writeScheduleSheet(xw *xlsx.Writer, items []service.CacheItem) error {
+	sheet, err := xw.NewSheet("Schedule")
+	if err != nil {
+		return fmt.Errorf("create schedule sheet: %w", err)
+	}
+	if err := sheet.WriteRow(headerCells(scheduleColumns)...); err != nil {
+		return fmt.Errorf("write schedule header: %w", err)
+	}
+	for _, item := range items {
+		schedule, err := service.Schedule(item.ExecuteResponse)
+		if err != nil {
+			return fmt.Errorf("schedule of item %s: %w", item.ID, err)
+		}
+		for _, inst := range schedule {
+			if err := sheet.WriteRow(
+				idCell(item.ID),
+				xlsx.Int(inst.Number),
+				dateCell(inst.Date),
+				xlsx.Money(inst.Payment),
+				xlsx.Money(inst.Principal),
+				xlsx.Money(inst.Interest),
+				xlsx.Money(inst.Balance),
+			); err != nil {
+				return fmt.Errorf("write schedule of item %s: %w", item.ID, err)
+			}
+		}
+	}
+	if err := sheet.Close(); err != nil {
+		return fmt.Errorf("close schedule sheet: %w", err)
+	}
+	return nil
+}
+
+func headerCells(names []string) []xlsx.Cell {
+	cells := make([]xlsx.Cell, len(names))
+	for i, name := range names {
+		cells[i] = xlsx.Header(name)
+	}
+	return cells
+}
+
+func itemCells(item service.CacheItem) []xlsx.Cell {
+	a := item.Aggregates
+	p := item.Params
+	return []xlsx.Cell{
+		idCell(item.ID),
+		xlsx.Money(p.ObjectCost),
+		xlsx.Money(p.InitialPayment),
+		xlsx.Int(p.Months),
+		xlsx.String(item.ProgramName()),
+		xlsx.Number(a.Rate),
+		xlsx.Money(a.LoanSum),
+		xlsx.Money(a.MonthlyPayment),
+		xlsx.Money(a.Overpayment),
+		dateCell(a.LastPaymentDate),
+	}
+}
+
+func idCell(id service.ID) xlsx.Cell {
+	if n, err := strconv.Atoi(string(id)); err == nil {
+		return xlsx.Int(n)
+	}
+	return xlsx.String(string(id))
+}
+
+func dateCell(s string) xlsx.Cell {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return xlsx.String(s)
+	}
+	return xlsx.Date(t)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
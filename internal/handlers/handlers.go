@@ -2,14 +2,162 @@
 package handlers
 
 import (
+	"net/http"
+
+	"sber_test/internal/auth"
+	"sber_test/internal/idempotency"
+	"sber_test/internal/ratelimit"
 	"sber_test/internal/service"
 
 	"github.com/go-chi/chi"
 )
 
-// RegisterRoutes registers HTTP routes for the application.
-func RegisterRoutes(r chi.Router, svc *service.Service) {
-	r.Use(Logger)
-	r.Post("/execute", Execute(svc))
-	r.Get("/cache", GetCache(svc))
+type options struct {
+	batch             BatchLimits
+	imports           ImportLimits
+	auth              *auth.Authenticator
+	trustTenantHeader bool
+	limiters          map[string]*ratelimit.Limiter
+	maxInFlight       int
+	idempotency       *idempotency.Store
+}
+
+// Option configures RegisterRoutes.
+type Option func(*options)
+
+// WithBatchLimits sets the limits of POST /execute/batch.
+func WithBatchLimits(l BatchLimits) Option {
+	return func(o *options) {
+		o.batch = l
+	}
+}
+
+// WithImportLimits sets the limits of POST /import.
+func WithImportLimits(l ImportLimits) Option {
+	return func(o *options) {
+		o.imports = l
+	}
+}
+
+// WithAuth requires clients to authenticate with a and enforces the scopes
+// of every route. Without it authentication is disabled.
+func WithAuth(a *auth.Authenticator) Option {
+	return func(o *options) {
+		o.auth = a
+	}
+}
+
+// WithTrustedTenantHeader makes the X-Tenant-ID header choose the tenant of
+// requests whose principal has none, e.g. when authentication is disabled.
+// A header naming another tenant than the principal's is rejected. Use it
+// only behind a proxy that sets the header itself.
+func WithTrustedTenantHeader() Option {
+	return func(o *options) {
+		o.trustTenantHeader = true
+	}
+}
+
+// WithRateLimits limits the rate of requests of every client per route.
+// limits are keyed by route: the /v1 path, e.g. "/v1/execute", which also
+// covers the unversioned alias, or the /v2 path. DefaultRoute applies to the
+// routes not listed; without it they are unlimited. opts configure the
+// limiter of every route, e.g. ratelimit.WithMaxKeys.
+func WithRateLimits(limits map[string]ratelimit.Limit, opts ...ratelimit.Option) Option {
+	return func(o *options) {
+		o.limiters = make(map[string]*ratelimit.Limiter, len(limits))
+		for route, l := range limits {
+			o.limiters[route] = ratelimit.New(l, opts...)
+		}
+	}
+}
+
+// WithMaxInFlight sheds API requests with 503 while n of them are being
+// served. Zero means unlimited.
+func WithMaxInFlight(n int) Option {
+	return func(o *options) {
+		o.maxInFlight = n
+	}
+}
+
+// WithIdempotency makes POST /execute honour the Idempotency-Key header,
+// keeping first responses in s. Without it the header is ignored.
+func WithIdempotency(s *idempotency.Store) Option {
+	return func(o *options) {
+		o.idempotency = s
+	}
+}
+
+// idempotent wraps h of route with Idempotent if WithIdempotency is set.
+func (o options) idempotent(route string, h http.Handler) http.Handler {
+	if o.idempotency == nil {
+		return h
+	}
+	return Idempotent(route, o.idempotency)(h)
+}
+
+// rateLimit returns the middleware limiting route.
+func (o options) rateLimit(route string) func(http.Handler) http.Handler {
+	l, ok := o.limiters[route]
+	if !ok {
+		l, ok = o.limiters[DefaultRoute]
+	}
+	if !ok {
+		return passThrough
+	}
+	return RateLimit(route, l)
+}
+
+func passThrough(next http.Handler) http.Handler {
+	return next
+}
+
+// RegisterRoutes registers HTTP routes for the application. The routes
+// answering in the format mandated by the spec live under /v1 and are also
+// served, with deprecation headers, at their unversioned paths; /v2 serves
+// the same calculations in a richer format. Every route but the OpenAPI
+// documents requires a scope; see WithAuth. Cached calculations and program
+// catalogues are those of the request's tenant; see Tenant. See also
+// WithRateLimits, WithMaxInFlight and WithIdempotency.
+func RegisterRoutes(r chi.Router, svc *service.Service, opts ...Option) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r.Use(RequestID, Trace, Logger, Metrics, Recoverer, Authenticate(o.auth), Tenant(o.trustTenantHeader))
+	// The limit is shared by the API routes only, so that probes and
+	// metrics still answer under load.
+	inFlight := passThrough
+	if o.maxInFlight > 0 {
+		inFlight = MaxInFlight(o.maxInFlight)
+	}
+	r.Route("/v1", func(r chi.Router) {
+		r.Use(inFlight)
+		v1Routes(svc, o)(r)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(inFlight, Deprecated("/v1"))
+		v1Routes(svc, o)(r)
+	})
+	r.Route("/v2", func(r chi.Router) {
+		r.Use(inFlight)
+		r.With(o.rateLimit("/v2/execute"), RequireScope(auth.ScopeExecute)).Method(http.MethodPost, "/execute", o.idempotent("/v2/execute", ExecuteV2(svc)))
+		r.With(o.rateLimit("/v2/cache"), RequireScope(auth.ScopeCacheRead)).Get("/cache", GetCacheV2(svc))
+		r.With(o.rateLimit("/v2/openapi.json")).Get("/openapi.json", OpenAPIV2)
+	})
+}
+
+func v1Routes(svc *service.Service, o options) func(chi.Router) {
+	return func(r chi.Router) {
+		handle := func(method, path, scope string, h http.Handler) {
+			r.With(o.rateLimit("/v1"+path), RequireScope(scope)).Method(method, path, h)
+		}
+		handle(http.MethodPost, "/execute", auth.ScopeExecute, o.idempotent("/v1/execute", Execute(svc)))
+		handle(http.MethodPost, "/execute/batch", auth.ScopeExecute, ExecuteBatch(svc, o.batch))
+		handle(http.MethodPost, "/import", auth.ScopeExecute, Import(svc, o.imports))
+		handle(http.MethodGet, "/cache", auth.ScopeCacheRead, GetCache(svc))
+		handle(http.MethodGet, "/cache/export", auth.ScopeCacheRead, ExportCache(svc))
+		handle(http.MethodGet, "/programs", auth.ScopeAdminPrograms, Programs(svc))
+		r.With(o.rateLimit("/v1/openapi.json")).Get("/openapi.json", OpenAPI)
+	}
 }
@@ -1,27 +1,73 @@
 package handlers
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
-	"sber_test/internal/repo/cache"
+	"sber_test/internal/auth"
+	"sber_test/internal/idempotency"
+	"sber_test/internal/logging"
+	"sber_test/internal/metrics"
+	"sber_test/internal/ratelimit"
 	"sber_test/internal/service"
+	"sber_test/internal/tenant"
+	"sber_test/internal/tracing"
+	"sber_test/internal/xlsx"
+
+	"github.com/go-chi/chi"
 )
 
-func TestHandlers(t *testing.T) {
-	cacheService := cache.New()
-	svc := service.New(cacheService)
+// spanRecorder collects exported spans.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) Export(data tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, data)
+	return nil
+}
+
+// TestMain runs the tests from the repository root, where programs.json is,
+// so that they don't depend on each other's working directory.
+func TestMain(m *testing.M) {
 	err := os.Chdir("../../")
 	if err != nil {
 		panic("failed to change directory: " + err.Error())
 	}
+	os.Exit(m.Run())
+}
+
+func TestHandlers(t *testing.T) {
 	t.Run("Test GetCache", func(t *testing.T) {
+		cacheService := service.NewStore(service.SequentialIDs(), 0)
+		svc := service.New(cacheService)
 		handler := GetCache(svc)
-		cacheService.Add(service.CacheItem{ID: 1})
+		cacheService.Insert(func(id service.ID) service.CacheItem { return service.CacheItem{ID: id} })
 
 		req, err := http.NewRequest("GET", "/cache", nil)
 		if err != nil {
@@ -34,7 +80,7 @@ func TestHandlers(t *testing.T) {
 			t.Errorf("expected status %v, got %v", http.StatusOK, rr.Code)
 		}
 
-		cacheService = cache.New()
+		cacheService = service.NewStore(service.SequentialIDs(), 0)
 		svc = service.New(cacheService)
 		handler = GetCache(svc)
 		req, err = http.NewRequest("GET", "/cache", nil)
@@ -50,7 +96,7 @@ func TestHandlers(t *testing.T) {
 	})
 
 	t.Run("Test Execute", func(t *testing.T) {
-		handler := Execute(svc)
+		handler := Execute(service.New(service.NewStore(service.SequentialIDs(), 0)))
 
 		tests := []struct {
 			body       service.ExecuteRequest
@@ -119,6 +165,542 @@ func TestHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("Test Execute cache hit", func(t *testing.T) {
+		handler := Execute(service.New(service.NewStore(service.SequentialIDs(), 0)))
+		body := []byte(`{"object_cost":8000000,"initial_payment":2000000,"months":200,"program":{"military":true}}`)
+
+		var ids []string
+		for _, want := range []string{"MISS", "HIT"} {
+			req, err := http.NewRequest("POST", "/execute", bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status %v, got %v", http.StatusOK, rr.Code)
+			}
+			if got := rr.Header().Get(HeaderCache); got != want {
+				t.Errorf("expected %s %q, got %q", HeaderCache, want, got)
+			}
+			ids = append(ids, rr.Header().Get(HeaderCacheID))
+		}
+		if ids[0] == "" || ids[0] != ids[1] {
+			t.Errorf("expected the same cache ID twice, got %v", ids)
+		}
+	})
+
+	t.Run("Test Execute concurrent IDs", func(t *testing.T) {
+		for name, ids := range map[string]func() service.ID{
+			"sequential":   service.SequentialIDs(),
+			"time-ordered": service.TimeOrderedIDs(),
+		} {
+			svc := service.New(service.NewStore(ids, 0))
+			handler := Execute(svc)
+
+			const n = 64
+			returned := make([]string, n)
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					body := fmt.Sprintf(`{"object_cost":5000000,"initial_payment":1000000,"months":%d,"program":{"base":true}}`, 12+i)
+					req := httptest.NewRequest("POST", "/execute", bytes.NewBufferString(body))
+					rr := httptest.NewRecorder()
+					handler.ServeHTTP(rr, req)
+					if rr.Code != http.StatusOK {
+						t.Errorf("%s: expected status %v, got %v", name, http.StatusOK, rr.Code)
+					}
+					returned[i] = rr.Header().Get(HeaderCacheID)
+				}(i)
+			}
+			wg.Wait()
+
+			items := svc.GetAll(context.Background())
+			if len(items) != n {
+				t.Fatalf("%s: expected %d items, got %d", name, n, len(items))
+			}
+			for _, item := range items {
+				i := item.Params.Months - 12
+				if string(item.ID) != returned[i] {
+					t.Errorf("%s: item for request %d has ID %q, caller got %q", name, i, item.ID, returned[i])
+				}
+			}
+		}
+	})
+
+	t.Run("Test ExportCache", func(t *testing.T) {
+		svc := service.New(service.NewStore(service.SequentialIDs(), 0))
+		for _, program := range []string{"military", "base"} {
+			req := service.ExecuteRequest{ObjectCost: 5000000, InitialPayment: 1000000, Months: 240, Program: map[string]bool{program: true}}
+			if _, err := svc.Calculate(context.Background(), req); err != nil {
+				t.Fatal(err)
+			}
+		}
+		handler := ExportCache(svc)
+		items := svc.GetAll(context.Background())
+
+		req := httptest.NewRequest("GET", "/cache/export?format=csv", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %v, got %v", http.StatusOK, rr.Code)
+		}
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != len(items)+1 {
+			t.Errorf("expected %d CSV records, got %d", len(items)+1, len(records))
+		}
+		if records[0][0] != "id" || records[1][4] != items[0].ProgramName() {
+			t.Errorf("unexpected CSV contents: %v", records[:2])
+		}
+
+		req = httptest.NewRequest("GET", "/cache/export?format=xlsx&schedule=true", nil)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %v, got %v", http.StatusOK, rr.Code)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := map[string]bool{}
+		for _, f := range zr.File {
+			parts[f.Name] = true
+		}
+		for _, name := range []string{"xl/workbook.xml", "xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml"} {
+			if !parts[name] {
+				t.Errorf("expected part %s in workbook", name)
+			}
+		}
+
+		req = httptest.NewRequest("GET", "/cache/export?format=pdf", nil)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status %v, got %v", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Test Import", func(t *testing.T) {
+		svc := service.New(service.NewStore(service.SequentialIDs(), 0))
+		handler := Import(svc, ImportLimits{MaxRows: 5})
+
+		csvBody := "object_cost;initial_payment;months;program\n" +
+			"5000000;1000000;240;salary\n" +
+			"5000000;1000000;240;salary,military\n" +
+			";;;\n" +
+			"abc;1000000;240;base\n"
+		var xlsxBody bytes.Buffer
+		xw := xlsx.NewWriter(&xlsxBody)
+		sheet, err := xw.NewSheet("Loans")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := [][]xlsx.Cell{
+			{xlsx.String("object_cost"), xlsx.String("initial_payment"), xlsx.String("months"), xlsx.String("salary"), xlsx.String("military"), xlsx.String("base")},
+			{xlsx.Number(5000000), xlsx.Number(1000000), xlsx.Int(240), xlsx.Bool(true), xlsx.Bool(false), xlsx.Bool(false)},
+			{xlsx.Number(5000000), xlsx.Number(1000000), xlsx.Int(240), xlsx.Bool(false), xlsx.Bool(false), xlsx.Bool(false)},
+			{xlsx.Number(8000000), xlsx.Number(2000000), xlsx.Int(200), xlsx.Bool(false), xlsx.Bool(true), xlsx.Bool(false)},
+		}
+		for _, row := range rows {
+			if err := sheet.WriteRow(row...); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := xw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		var form bytes.Buffer
+		mw := multipart.NewWriter(&form)
+		part, err := mw.CreateFormFile("file", "loans.xlsx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(xlsxBody.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := mw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name        string
+			contentType string
+			body        []byte
+			want        []importRow
+		}{
+			{
+				name:        "csv",
+				contentType: "text/csv",
+				body:        []byte(csvBody),
+				want: []importRow{
+					{Row: 2, ID: "0"},
+					{Row: 3, Error: "choose only 1 program", Code: CodeChooseOnlyOneProgram},
+					{Row: 5, Error: `invalid value for object_cost: "abc"`, Code: CodeInvalidRequest},
+				},
+			},
+			{
+				name:        "xlsx",
+				contentType: mw.FormDataContentType(),
+				body:        form.Bytes(),
+				want: []importRow{
+					{Row: 2, ID: "0", Cached: true},
+					{Row: 3, Error: "choose program", Code: CodeChooseProgram},
+					{Row: 4, ID: "1"},
+				},
+			},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest("POST", "/import", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("%s: expected status %v, got %v: %s", tt.name, http.StatusOK, rr.Code, rr.Body)
+			}
+			var report importReport
+			if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report.Rows, tt.want) {
+				t.Errorf("%s: expected rows %+v, got %+v", tt.name, tt.want, report.Rows)
+			}
+		}
+
+		req := httptest.NewRequest("POST", "/import", bytes.NewBufferString("a,b\n1,2\n"))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status %v for missing header, got %v", http.StatusBadRequest, rr.Code)
+		}
+
+		req = httptest.NewRequest("POST", "/import", strings.NewReader(csvBody+"1;1;1;base\n"))
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), CodeImportTooLarge) {
+			t.Errorf("expected status %v for too many rows, got %v: %s", http.StatusRequestEntityTooLarge, rr.Code, rr.Body)
+		}
+
+		// The reference workbook lists the inputs as labels with the values
+		// beside them, next to the rate table and the expected aggregates.
+		example, err := os.ReadFile("example_golang.xlsx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		exampleSvc := service.New(service.NewStore(service.SequentialIDs(), 0))
+		rr = httptest.NewRecorder()
+		Import(exampleSvc, DefaultImportLimits).ServeHTTP(rr, httptest.NewRequest("POST", "/import", bytes.NewReader(example)))
+		var report importReport
+		if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if want := []importRow{{Row: 3, ID: "0"}}; rr.Code != http.StatusOK || !reflect.DeepEqual(report.Rows, want) {
+			t.Fatalf("expected the reference workbook imported as %+v, got %d %+v", want, rr.Code, report.Rows)
+		}
+		items := exampleSvc.GetAll(context.Background())
+		if len(items) != 1 || items[0].Aggregates.Rate != 8 || items[0].Aggregates.LoanSum != 4000000 ||
+			math.Round(items[0].Aggregates.MonthlyPayment) != 33458 {
+			t.Errorf("expected the aggregates of the reference workbook, got %+v", items)
+		}
+
+		req = httptest.NewRequest("POST", "/import", bytes.NewReader(make([]byte, maxImportSize+1)))
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), CodeRequestTooLarge) {
+			t.Errorf("expected status %v for an oversized upload, got %v: %s", http.StatusRequestEntityTooLarge, rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("Test ExecuteBatch", func(t *testing.T) {
+		svc := service.New(service.NewStore(service.SequentialIDs(), 0))
+		handler := ExecuteBatch(svc, BatchLimits{MaxSize: 3, Workers: 2})
+		body := `[
+			{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{"salary":true}},
+			{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{}},
+			{"object_cost":8000000,"initial_payment":2000000,"months":200,"program":{"military":true}}
+		]`
+
+		for _, tt := range []struct {
+			query  string
+			stored int
+		}{
+			{query: "?store=false", stored: 0},
+			{query: "", stored: 2},
+		} {
+			req := httptest.NewRequest("POST", "/execute/batch"+tt.query, bytes.NewBufferString(body))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status %v, got %v", http.StatusOK, rr.Code)
+			}
+			var out batchResponse
+			if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+				t.Fatal(err)
+			}
+			if out.Succeeded != 2 || out.Failed != 1 || len(out.Results) != 3 {
+				t.Fatalf("unexpected batch summary: %+v", out)
+			}
+			for i, item := range out.Results {
+				if item.Index != i {
+					t.Errorf("expected result %d in position %d", item.Index, i)
+				}
+			}
+			if out.Results[1].Status != http.StatusBadRequest || out.Results[1].Error != "choose program" {
+				t.Errorf("unexpected failed item: %+v", out.Results[1])
+			}
+			if out.Results[2].Result == nil || out.Results[2].Result.Aggregates.Rate != 9 {
+				t.Errorf("unexpected result: %+v", out.Results[2])
+			}
+			if got := len(svc.GetAll(context.Background())); got != tt.stored {
+				t.Errorf("store%s: expected %d cached items, got %d", tt.query, tt.stored, got)
+			}
+		}
+
+		for _, tt := range []struct {
+			body   string
+			status int
+		}{
+			{body: `[]`, status: http.StatusBadRequest},
+			{body: `{}`, status: http.StatusBadRequest},
+			{body: `[{},{},{},{}]`, status: http.StatusRequestEntityTooLarge},
+		} {
+			req := httptest.NewRequest("POST", "/execute/batch", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("%s: expected status %v, got %v", tt.body, tt.status, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Test NDJSON", func(t *testing.T) {
+		svc := service.New(service.NewStore(service.SequentialIDs(), 0))
+		batchBody := `[
+			{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{"salary":true}},
+			{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{}},
+			{"object_cost":8000000,"initial_payment":2000000,"months":200,"program":{"military":true}}
+		]`
+		req := httptest.NewRequest("POST", "/execute/batch", bytes.NewBufferString(batchBody))
+		req.Header.Set("Accept", "application/x-ndjson")
+		rr := httptest.NewRecorder()
+		ExecuteBatch(svc, BatchLimits{}).ServeHTTP(rr, req)
+
+		if ct := rr.Header().Get("Content-Type"); ct != contentTypeNDJSON {
+			t.Errorf("expected content type %q, got %q", contentTypeNDJSON, ct)
+		}
+		if !rr.Flushed {
+			t.Error("expected the response to be flushed")
+		}
+		seen := map[int]batchItem{}
+		dec := json.NewDecoder(rr.Body)
+		for dec.More() {
+			var item batchItem
+			if err := dec.Decode(&item); err != nil {
+				t.Fatal(err)
+			}
+			seen[item.Index] = item
+		}
+		if len(seen) != 3 || seen[1].Status != http.StatusBadRequest {
+			t.Errorf("unexpected batch lines: %+v", seen)
+		}
+
+		req = httptest.NewRequest("GET", "/cache", nil)
+		req.Header.Set("Accept", "application/json, application/x-ndjson;q=0.9")
+		rr = httptest.NewRecorder()
+		GetCache(svc).ServeHTTP(rr, req)
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 lines, got %d: %q", len(lines), rr.Body.String())
+		}
+		var item service.CacheItem
+		if err := json.Unmarshal([]byte(lines[1]), &item); err != nil {
+			t.Fatal(err)
+		}
+		if item.ID != "1" {
+			t.Errorf("expected second line to hold item 1, got %q", item.ID)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req = httptest.NewRequest("GET", "/cache", nil).WithContext(ctx)
+		req.Header.Set("Accept", "application/x-ndjson")
+		rr = httptest.NewRecorder()
+		GetCache(svc).ServeHTTP(rr, req)
+		if rr.Body.Len() != 0 {
+			t.Errorf("expected nothing streamed to a cancelled client, got %q", rr.Body.String())
+		}
+	})
+
+	t.Run("Test error responses", func(t *testing.T) {
+		handler := Execute(service.New(service.NewStore(service.SequentialIDs(), 0)))
+		tests := []struct {
+			body string
+			want ErrorResponse
+		}{
+			{
+				body: `{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{"qw\"erty":true}}`,
+				want: ErrorResponse{Error: "validation failed", Code: CodeValidationFailed, Field: `/program/qw"erty`},
+			},
+			{
+				body: `{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{"salary":false}}`,
+				want: ErrorResponse{Error: "choose program", Code: CodeChooseProgram, Field: "/program"},
+			},
+			{
+				body: `{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{"salary":true,"base":true}}`,
+				want: ErrorResponse{Error: "choose only 1 program", Code: CodeChooseOnlyOneProgram, Field: "/program"},
+			},
+			{
+				body: `{"object_cost":5000000,"initial_payment":100,"months":240,"program":{"salary":true}}`,
+				want: ErrorResponse{Error: "the initial payment should be more", Code: CodeInitialPaymentLow, Field: "/initial_payment"},
+			},
+			{
+				body: `{"object_cost":`,
+				want: ErrorResponse{Error: "malformed request", Code: CodeInvalidRequest},
+			},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest("POST", "/execute", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("%s: expected JSON content type, got %q", tt.body, ct)
+			}
+			var got ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%s: invalid JSON error body %q: %v", tt.body, rr.Body.String(), err)
+			}
+			got.Details = nil
+			if got != tt.want {
+				t.Errorf("%s: expected %+v, got %+v", tt.body, tt.want, got)
+			}
+		}
+
+		rr := httptest.NewRecorder()
+		writeJSON(rr, http.StatusOK, map[string]float64{"nan": math.NaN()})
+		if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), CodeEncodingFailed) {
+			t.Errorf("expected encoding failure to be reported, got %v %q", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Test validation errors", func(t *testing.T) {
+		handler := Execute(service.New(service.NewStore(service.SequentialIDs(), 0)))
+		body := `{"object_cost":-1,"initial_payment":"x","months":240.5,"program":{"salary":true,"gold":true},"extra":1}`
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/execute", bytes.NewBufferString(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %v", rr.Code)
+		}
+		var got struct {
+			Error   string               `json:"error"`
+			Code    string               `json:"code"`
+			Field   string               `json:"field"`
+			Details []service.FieldError `json:"details"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, f := range got.Details {
+			fields = append(fields, f.Field)
+		}
+		want := []string{"/extra", "/initial_payment", "/months", "/object_cost", "/program/gold"}
+		if got.Code != CodeValidationFailed || got.Field != "" || !reflect.DeepEqual(fields, want) {
+			t.Errorf("expected all of %v reported, got %+v", want, got)
+		}
+
+		rr = httptest.NewRecorder()
+		large := `{"object_cost":5000000,"pad":"` + strings.Repeat("x", maxRequestSize) + `"}`
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/execute", strings.NewReader(large)))
+		if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), CodeRequestTooLarge) {
+			t.Errorf("expected 413 for an oversized body, got %v %q", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Test service error statuses", func(t *testing.T) {
+		tests := []struct {
+			err    error
+			status int
+			want   ErrorResponse
+		}{
+			{
+				err:    &service.UnknownProgramError{Name: "a/b"},
+				status: http.StatusBadRequest,
+				want:   ErrorResponse{Error: "unknown program: a/b", Code: CodeUnknownProgram, Field: "/program/a~1b"},
+			},
+			{
+				err:    service.ErrChooseProgram,
+				status: http.StatusBadRequest,
+				want:   ErrorResponse{Error: "choose program", Code: CodeChooseProgram, Field: "/program"},
+			},
+			{
+				err:    service.ErrChooseOnlyOneProgram,
+				status: http.StatusBadRequest,
+				want:   ErrorResponse{Error: "choose only 1 program", Code: CodeChooseOnlyOneProgram, Field: "/program"},
+			},
+			{
+				err:    service.ErrInitialPaymentLow,
+				status: http.StatusBadRequest,
+				want:   ErrorResponse{Error: "the initial payment should be more", Code: CodeInitialPaymentLow, Field: "/initial_payment"},
+			},
+			{
+				err:    fmt.Errorf("%w: 2 >= 1", service.ErrFirstPaymentExceedsLoan),
+				status: http.StatusUnprocessableEntity,
+				want:   ErrorResponse{Error: "first payment exceeds loan sum: 2 >= 1", Code: CodeFirstPaymentExceedsLoan, Field: "/initial_payment"},
+			},
+			{
+				err:    fmt.Errorf("%w: unable to read programs.json: %w", service.ErrCatalogueUnavailable, os.ErrNotExist),
+				status: http.StatusServiceUnavailable,
+				want:   ErrorResponse{Error: "program catalogue unavailable", Code: CodeCatalogueUnavailable},
+			},
+			{
+				err:    errors.New("boom"),
+				status: http.StatusInternalServerError,
+				want:   ErrorResponse{Error: "internal error", Code: CodeInternal},
+			},
+		}
+		for _, tt := range tests {
+			rr := httptest.NewRecorder()
+			writeServiceError(rr, httptest.NewRequest("POST", "/execute", nil), tt.err)
+			if rr.Code != tt.status {
+				t.Errorf("%v: expected status %v, got %v", tt.err, tt.status, rr.Code)
+			}
+			var got ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%v: expected body %+v, got %+v", tt.err, tt.want, got)
+			}
+		}
+
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chdir(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := os.Chdir(wd); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		req := httptest.NewRequest("POST", "/execute", bytes.NewBufferString(`{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{"base":true}}`))
+		rr := httptest.NewRecorder()
+		Execute(service.New(service.NewStore(service.SequentialIDs(), 0))).ServeHTTP(rr, req)
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status %v without programs.json, got %v", http.StatusServiceUnavailable, rr.Code)
+		}
+	})
+
 	t.Run("Test Logger", func(t *testing.T) {
 		handler := Logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
@@ -136,4 +718,894 @@ func TestHandlers(t *testing.T) {
 			t.Errorf("expected status %v, got %v", http.StatusOK, rr.Code)
 		}
 	})
+
+	t.Run("Test OpenAPI", func(t *testing.T) {
+		specs := map[string]apiSpec{}
+		for version, doc := range map[string][]byte{"": openAPI, "/v1": openAPI, "/v2": openAPIV2} {
+			spec, err := loadSpec(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			specs[version] = spec
+		}
+		// version splits a routed path into its API version prefix and the
+		// path documented in that version's spec.
+		version := func(path string) (string, string) {
+			for _, prefix := range []string{"/v1", "/v2"} {
+				if rest, ok := strings.CutPrefix(path, prefix); ok {
+					return prefix, rest
+				}
+			}
+			return "", path
+		}
+
+		r := chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)))
+
+		var routes, documented []string
+		if err := chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			routes = append(routes, method+" "+route)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for prefix, spec := range specs {
+			for _, op := range spec.operations() {
+				method, path, _ := strings.Cut(op, " ")
+				documented = append(documented, method+" "+prefix+path)
+			}
+		}
+		sort.Strings(routes)
+		sort.Strings(documented)
+		if !reflect.DeepEqual(routes, documented) {
+			t.Errorf("registered routes %v don't match the documented ones %v", routes, documented)
+		}
+
+		const valid = `{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{"salary":true}}`
+		steps := []struct {
+			method, path, accept, body string
+		}{
+			{"GET", "/cache", "", ""},
+			{"GET", "/v2/cache", "", ""},
+			{"POST", "/execute", "", valid},
+			{"POST", "/v1/execute", "", valid},
+			{"POST", "/v2/execute", "", valid},
+			{"POST", "/v2/execute?schedule=true", "", `{"object_cost":1200,"initial_payment":240,"months":12,"program":{"base":true}}`},
+			{"POST", "/execute", "", `{"object_cost":-1,"months":0,"program":{"gold":true}}`},
+			{"POST", "/v2/execute", "", `{"object_cost":-1,"months":0,"program":{"gold":true}}`},
+			{"POST", "/execute", "", `{"object_cost":5000000,"initial_payment":9000000,"months":240,"program":{"base":true}}`},
+			{"POST", "/execute", "", `{`},
+			{"GET", "/cache", "", ""},
+			{"GET", "/cache", contentTypeNDJSON, ""},
+			{"GET", "/v2/cache?schedule=true", "", ""},
+			{"GET", "/v2/cache", contentTypeNDJSON, ""},
+			{"GET", "/cache/export?format=pdf", "", ""},
+			{"POST", "/execute/batch", "", `[` + valid + `,{"months":"x"},{"object_cost":5000000,"initial_payment":100,"months":240,"program":{"base":true}}]`},
+			{"POST", "/v1/execute/batch?store=false", contentTypeNDJSON, `[` + valid + `]`},
+			{"POST", "/execute/batch", "", `[]`},
+			{"POST", "/import", "", "object_cost,initial_payment,months,program\n5000000,1000000,240,base\n1,x,2,base\n"},
+			{"POST", "/import", contentTypeNDJSON, "object_cost,initial_payment,months,program\n5000000,1000000,240,military\n"},
+			{"POST", "/import", "", "no header"},
+			{"GET", "/openapi.json", "", ""},
+			{"GET", "/v2/openapi.json", "", ""},
+			{"GET", "/v1/programs", "", ""},
+		}
+		for _, step := range steps {
+			req := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+			if step.accept != "" {
+				req.Header.Set("Accept", step.accept)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			path, _, _ := strings.Cut(step.path, "?")
+			prefix, path := version(path)
+			if err := specs[prefix].checkResponse(step.method, path, rr); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	t.Run("Test API versions", func(t *testing.T) {
+		r := chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)))
+		const body = `{"object_cost":1200,"initial_payment":240,"months":12,"program":{"base":true}}`
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/execute", strings.NewReader(body)))
+		if rr.Header().Get("Deprecation") != "true" || rr.Header().Get("Link") != `</v1/execute>; rel="successor-version"` {
+			t.Errorf("expected deprecation headers on the unversioned path, got %v", rr.Header())
+		}
+		var v1 struct {
+			Result service.ExecuteResponse `json:"result"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &v1); err != nil {
+			t.Fatal(err)
+		}
+
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/execute", strings.NewReader(body)))
+		if rr.Header().Get("Deprecation") != "" {
+			t.Errorf("expected no deprecation headers under /v1, got %v", rr.Header())
+		}
+
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/v2/execute?schedule=true", strings.NewReader(body)))
+		var v2 CalculationV2
+		if err := json.Unmarshal(rr.Body.Bytes(), &v2); err != nil {
+			t.Fatal(err)
+		}
+		if !v2.Cached || v2.ID != "0" || v2.Program != "base" || len(v2.Schedule) != 12 {
+			t.Errorf("expected the cached v1 calculation with a 12 month schedule, got %+v", v2)
+		}
+		if want := money(v1.Result.Aggregates.MonthlyPayment); v2.Aggregates.MonthlyPayment != want || v2.Params.ObjectCost != "1200.00" {
+			t.Errorf("expected monthly payment %s and object cost 1200.00, got %+v", want, v2)
+		}
+	})
+
+	t.Run("Test fractional rates", func(t *testing.T) {
+		path := t.TempDir() + "/rates.yaml"
+		if err := os.WriteFile(path, []byte("version: \"2\"\nprogram_rates:\n  base: 7.125\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		r := chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0), service.WithProgramsFile(path)))
+		const body = `{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{"base":true}}`
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/programs", nil))
+		var catalogue service.Catalogue
+		if err := json.Unmarshal(rr.Body.Bytes(), &catalogue); err != nil || catalogue.Rates["base"] != 7.125 {
+			t.Errorf("expected the fractional rate in the catalogue, got %s", rr.Body)
+		}
+
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/execute", strings.NewReader(body)))
+		if !strings.Contains(rr.Body.String(), `"rate":7.125,`) || !strings.Contains(rr.Body.String(), `"monthly_payment":31312.79,`) {
+			t.Errorf("expected the /v1 calculation at 7.125%%, got %s", rr.Body)
+		}
+
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/v2/execute", strings.NewReader(body)))
+		var v2 CalculationV2
+		if err := json.Unmarshal(rr.Body.Bytes(), &v2); err != nil {
+			t.Fatal(err)
+		}
+		if v2.Aggregates.Rate != "7.125" || v2.Aggregates.MonthlyPayment != "31312.79" {
+			t.Errorf("expected the /v2 calculation at 7.125%%, got %+v", v2.Aggregates)
+		}
+
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/cache/export?format=csv", nil))
+		if !strings.Contains(rr.Body.String(), ",base,7.125,") {
+			t.Errorf("expected the fractional rate in the export, got %s", rr.Body)
+		}
+	})
+
+	t.Run("Test Metrics", func(t *testing.T) {
+		r := chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)))
+		before := httpRequests.Value("POST", "/v1/execute", "400")
+		failures := requestFailures.Value(CodeValidationFailed)
+		unmatched := httpRequests.Value("GET", unmatchedRoute, "404")
+		custom := httpRequests.Value(otherMethod, unmatchedRoute, "405")
+
+		for _, path := range []string{"/v1/execute", "/v1/execute"} {
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, strings.NewReader(`{"months":0}`)))
+		}
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/no/such/path/123", nil))
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/v1/execute", nil))
+
+		if got := httpRequests.Value("POST", "/v1/execute", "400") - before; got != 2 {
+			t.Errorf("expected 2 requests counted under the route pattern, got %v", got)
+		}
+		if got := requestFailures.Value(CodeValidationFailed) - failures; got != 2 {
+			t.Errorf("expected 2 validation failures, got %v", got)
+		}
+		if got := httpRequests.Value("GET", unmatchedRoute, "404") - unmatched; got != 1 {
+			t.Errorf("expected the unknown path counted as %s, got %v", unmatchedRoute, got)
+		}
+		if got := httpRequests.Value(otherMethod, unmatchedRoute, "405") - custom; got != 1 {
+			t.Errorf("expected the custom method counted as %s, got %v", otherMethod, got)
+		}
+		if got := httpRequests.Value("BREW", unmatchedRoute, "405"); got != 0 {
+			t.Errorf("expected no series for the custom method, got %v", got)
+		}
+		if httpDuration.Count("POST", "/v1/execute", "400") == 0 {
+			t.Error("expected latency observations for the route")
+		}
+	})
+
+	t.Run("Test request IDs in logs", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := logging.New(&buf, logging.FormatJSON, slog.LevelDebug)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(logger)
+
+		r := chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)))
+		req := httptest.NewRequest("POST", "/v1/execute", strings.NewReader(`{"object_cost":1200,"initial_payment":240,"months":12,"program":{"base":true}}`))
+		req.Header.Set(HeaderRequestID, "req-42")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if got := rr.Header().Get(HeaderRequestID); got != "req-42" {
+			t.Errorf("expected the request ID echoed, got %q", got)
+		}
+
+		var messages []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var rec map[string]any
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatal(err)
+			}
+			if rec[logging.RequestIDKey] != "req-42" {
+				t.Errorf("expected request_id in %s", line)
+			}
+			messages = append(messages, rec["msg"].(string))
+			if rec["msg"] == logging.AccessMessage && (rec["route"] != "/v1/execute" || rec["status"] != float64(200) || rec["bytes"] == float64(0)) {
+				t.Errorf("unexpected access record %s", line)
+			}
+		}
+		if want := []string{"loan calculated", logging.AccessMessage}; !reflect.DeepEqual(messages, want) {
+			t.Errorf("expected log messages %v, got %v", want, messages)
+		}
+
+		req = httptest.NewRequest("GET", "/v1/cache", nil)
+		req.Header.Set(HeaderRequestID, "bad id\n")
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if got := rr.Header().Get(HeaderRequestID); len(got) != 32 {
+			t.Errorf("expected an invalid request ID to be replaced, got %q", got)
+		}
+	})
+
+	t.Run("Test response capture", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := logging.New(&buf, logging.FormatJSON, slog.LevelInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(logger)
+
+		r := chi.NewRouter()
+		r.Use(Logger, Metrics)
+		r.Get("/stream", func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("{}\n"))
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("expected flushing through the middleware to work, got %v", err)
+			}
+		})
+		r.Get("/panic", func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		})
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", "/stream", nil))
+		if !rr.Flushed {
+			t.Error("expected the response to be flushed")
+		}
+
+		before := httpRequests.Value("GET", "/panic", "500")
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected the panic to propagate")
+				}
+			}()
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+		}()
+		if got := httpRequests.Value("GET", "/panic", "500") - before; got != 1 {
+			t.Errorf("expected the panic counted as 500, got %v", got)
+		}
+
+		var records []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var rec map[string]any
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, rec)
+		}
+		if len(records) != 2 {
+			t.Fatalf("expected 2 access records, got %d", len(records))
+		}
+		if records[0]["status"] != float64(200) || records[0]["bytes"] != float64(3) {
+			t.Errorf("unexpected access record for the stream %v", records[0])
+		}
+		if records[1]["status"] != float64(500) {
+			t.Errorf("expected the panic logged as 500, got %v", records[1])
+		}
+	})
+
+	t.Run("Test panic recovery", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := logging.New(&buf, logging.FormatJSON, slog.LevelInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(logger)
+
+		r := chi.NewRouter()
+		r.Use(RequestID, Logger, Metrics, Recoverer)
+		r.Get("/boom", func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		})
+		r.Get("/partial", func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("{"))
+			panic("boom")
+		})
+		r.Get("/abort", func(http.ResponseWriter, *http.Request) {
+			panic(http.ErrAbortHandler)
+		})
+
+		before := panics.Value("/boom")
+		requests := httpRequests.Value("GET", "/boom", "500")
+		req := httptest.NewRequest("GET", "/boom", nil)
+		req.Header.Set(HeaderRequestID, "req-boom")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Fatalf("expected status 500, got %d", rr.Code)
+		}
+		var body ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if want := (ErrorResponse{Error: "internal error", Code: CodeInternal, RequestID: "req-boom"}); body != want {
+			t.Errorf("expected %+v, got %+v", want, body)
+		}
+		if got := panics.Value("/boom") - before; got != 1 {
+			t.Errorf("expected the panic counted once, got %v", got)
+		}
+		if got := httpRequests.Value("GET", "/boom", "500") - requests; got != 1 {
+			t.Errorf("expected the request counted as 500, got %v", got)
+		}
+
+		var logged bool
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var rec map[string]any
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatal(err)
+			}
+			if rec["msg"] != "panic recovered" {
+				continue
+			}
+			logged = true
+			stack, _ := rec["stack"].(string)
+			if rec["panic"] != "boom" || rec[logging.RequestIDKey] != "req-boom" || !strings.Contains(stack, "handlers_test.go") {
+				t.Errorf("unexpected panic record %s", line)
+			}
+		}
+		if !logged {
+			t.Error("expected the panic to be logged")
+		}
+
+		for _, path := range []string{"/partial", "/abort"} {
+			func() {
+				defer func() {
+					if p := recover(); p != http.ErrAbortHandler { //nolint:errorlint,goerr113 // sentinel
+						t.Errorf("%s: expected the connection aborted, got %v", path, p)
+					}
+				}()
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+			}()
+		}
+		if got := panics.Value("/abort"); got != 0 {
+			t.Errorf("expected http.ErrAbortHandler not counted as a panic, got %v", got)
+		}
+	})
+
+	t.Run("Test tracing", func(t *testing.T) {
+		spans := &spanRecorder{}
+		defer tracing.SetDefault(tracing.Default())
+		tracing.SetDefault(tracing.New(spans))
+		var logs bytes.Buffer
+		logger, err := logging.New(&logs, logging.FormatJSON, slog.LevelInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(logger)
+
+		r := chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)))
+		const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+		req := httptest.NewRequest("POST", "/v1/execute", strings.NewReader(`{"object_cost":1200,"initial_payment":240,"months":12,"program":{"base":true}}`))
+		req.Header.Set(tracing.HeaderTraceparent, "00-"+traceID+"-"+parentID+"-01")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		sc, err := tracing.ParseTraceparent(rr.Header().Get(tracing.HeaderTraceparent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sc.TraceID.String() != traceID || !sc.Sampled {
+			t.Errorf("expected the caller's trace continued, got %s", sc.Traceparent())
+		}
+
+		ids, parents := map[string]string{}, map[string]string{}
+		for _, span := range spans.spans {
+			if span.TraceID != traceID {
+				t.Errorf("expected span %s in trace %s, got %s", span.Name, traceID, span.TraceID)
+			}
+			ids[span.Name], parents[span.Name] = span.SpanID, span.ParentSpanID
+		}
+		// Every step hangs off its expected parent, up to the caller's span.
+		for child, parent := range map[string]string{
+			"decode":            "POST /v1/execute",
+			"service.calculate": "POST /v1/execute",
+			"program.lookup":    "service.calculate",
+			"cache.lookup":      "service.calculate",
+			"calculate":         "service.calculate",
+			"cache.store":       "service.calculate",
+		} {
+			if parents[child] == "" || parents[child] != ids[parent] {
+				t.Errorf("expected %s to be a child of %s, got parents %v of spans %v", child, parent, parents, ids)
+			}
+		}
+		if parents["POST /v1/execute"] != parentID {
+			t.Errorf("expected the request span to be a child of the caller's, got %v", parents)
+		}
+
+		var rec map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if rec["msg"] != logging.AccessMessage || rec[logging.TraceIDKey] != traceID {
+			t.Errorf("expected the trace ID in the access record, got %v", rec)
+		}
+	})
+
+	t.Run("Test probes", func(t *testing.T) {
+		get := func(r http.Handler, path string, v any) int {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+			if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			return rr.Code
+		}
+
+		store := service.NewStore(service.SequentialIDs(), 0)
+		svc := service.New(store)
+		health := NewHealth(svc)
+		r := chi.NewRouter()
+		RegisterProbes(r, health)
+
+		var status map[string]string
+		if code := get(r, "/healthz", &status); code != http.StatusOK || status["status"] != "ok" {
+			t.Errorf("expected a healthy process, got %d %v", code, status)
+		}
+
+		var ready ReadyResponse
+		want := ReadyResponse{Status: StatusReady, Checks: map[string]string{"catalogue": "ok", "cache": "ok"}}
+		if code := get(r, "/readyz", &ready); code != http.StatusOK || !reflect.DeepEqual(ready, want) {
+			t.Errorf("expected %+v, got %d %+v", want, code, ready)
+		}
+
+		var version VersionResponse
+		if code := get(r, "/version", &version); code != http.StatusOK || version.GoVersion != runtime.Version() ||
+			version.Commit == "" || version.BuildTime == "" || version.CatalogueVersion == "" {
+			t.Errorf("unexpected version %d %+v", code, version)
+		}
+
+		// A writer holding the cache makes the service not ready until it's done.
+		health.timeout = 20 * time.Millisecond
+		locked, release := make(chan struct{}), make(chan struct{})
+		go store.Insert(func(id service.ID) service.CacheItem {
+			close(locked)
+			<-release
+			return service.CacheItem{ID: id}
+		})
+		<-locked
+		ready = ReadyResponse{}
+		if code := get(r, "/readyz", &ready); code != http.StatusServiceUnavailable || ready.Status != StatusNotReady ||
+			!strings.Contains(ready.Checks["cache"], "not writable") || ready.Checks["catalogue"] != "ok" {
+			t.Errorf("expected a stuck cache reported, got %d %+v", code, ready)
+		}
+		close(release)
+		ready = ReadyResponse{}
+		if code := get(r, "/readyz", &ready); code != http.StatusOK || !reflect.DeepEqual(ready, want) {
+			t.Errorf("expected ready once the writer is done, got %d %+v", code, ready)
+		}
+
+		health.Drain()
+		ready = ReadyResponse{}
+		if code := get(r, "/readyz", &ready); code != http.StatusServiceUnavailable || ready.Status != StatusDraining {
+			t.Errorf("expected not ready while draining, got %d %+v", code, ready)
+		}
+		if code := get(r, "/healthz", &status); code != http.StatusOK {
+			t.Errorf("expected still alive while draining, got %d", code)
+		}
+
+		broken := chi.NewRouter()
+		RegisterProbes(broken, NewHealth(service.New(service.NewStore(service.SequentialIDs(), 0), service.WithProgramsFile("missing.json"))))
+		ready = ReadyResponse{}
+		if code := get(broken, "/readyz", &ready); code != http.StatusServiceUnavailable || ready.Status != StatusNotReady ||
+			!strings.Contains(ready.Checks["catalogue"], "missing.json") || ready.Checks["cache"] != "ok" {
+			t.Errorf("expected a missing catalogue reported, got %d %+v", code, ready)
+		}
+		version = VersionResponse{}
+		if code := get(broken, "/version", &version); code != http.StatusOK || version.CatalogueVersion != "" {
+			t.Errorf("expected no catalogue version, got %d %+v", code, version)
+		}
+	})
+
+	t.Run("Test authentication", func(t *testing.T) {
+		spec, err := loadSpec(openAPI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret := []byte("0123456789abcdef0123456789abcdef")
+		a, err := auth.New([]auth.APIKey{
+			{Key: "exec-key", ClientID: "bank-a", Tenant: "partners", Scopes: []string{auth.ScopeExecute}},
+			{Key: "read-key", ClientID: "auditor", Tenant: "partners", Scopes: []string{auth.ScopeCacheRead}},
+		}, auth.NewJWTVerifier([]auth.Key{{ID: "k1", Key: secret}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)), WithAuth(a))
+
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"k1"}`))
+		claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"bank-b","tenant":"partners","exp":%d,"scope":"calc:execute cache:read admin:programs"}`, time.Now().Add(time.Hour).Unix())))
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(header + "." + claims))
+		jwt := header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+		const body = `{"object_cost":1200,"initial_payment":240,"months":12,"program":{"base":true}}`
+		steps := []struct {
+			name, method, path, key, bearer string
+			wantStatus                      int
+			wantCode, wantChallenge         string
+		}{
+			{"public document", "GET", "/v1/openapi.json", "", "", http.StatusOK, "", ""},
+			{"no credentials", "POST", "/v1/execute", "", "", http.StatusUnauthorized, CodeUnauthorized, "Bearer"},
+			{"unknown key", "GET", "/v1/openapi.json", "nope", "", http.StatusUnauthorized, CodeUnauthorized, `Bearer error="invalid_token"`},
+			{"invalid token", "GET", "/v1/cache", "", jwt + "x", http.StatusUnauthorized, CodeUnauthorized, `Bearer error="invalid_token"`},
+			{"missing scope", "POST", "/v1/execute", "read-key", "", http.StatusForbidden, CodeForbidden, `Bearer error="insufficient_scope", scope="calc:execute"`},
+			{"API key", "POST", "/v1/execute", "exec-key", "", http.StatusOK, "", ""},
+			{"JWT", "POST", "/execute", "", jwt, http.StatusOK, "", ""},
+			{"admin route", "GET", "/v1/programs", "read-key", "", http.StatusForbidden, CodeForbidden, `Bearer error="insufficient_scope", scope="admin:programs"`},
+			{"admin scope", "GET", "/v1/programs", "", jwt, http.StatusOK, "", ""},
+			{"cache read", "GET", "/v1/cache", "read-key", "", http.StatusOK, "", ""},
+		}
+		var items []service.CacheItem
+		for _, step := range steps {
+			req := httptest.NewRequest(step.method, step.path, strings.NewReader(body))
+			if step.key != "" {
+				req.Header.Set(auth.HeaderAPIKey, step.key)
+			}
+			if step.bearer != "" {
+				req.Header.Set(auth.HeaderAuthorization, "Bearer "+step.bearer)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != step.wantStatus {
+				t.Errorf("%s: expected status %d, got %d %s", step.name, step.wantStatus, rr.Code, rr.Body)
+				continue
+			}
+			if got := rr.Header().Get("WWW-Authenticate"); got != step.wantChallenge {
+				t.Errorf("%s: expected challenge %q, got %q", step.name, step.wantChallenge, got)
+			}
+			if step.wantCode != "" {
+				var resp ErrorResponse
+				if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Code != step.wantCode {
+					t.Errorf("%s: expected code %s, got %s", step.name, step.wantCode, rr.Body)
+				}
+			}
+			if err := spec.checkResponse(step.method, strings.TrimPrefix(step.path, "/v1"), rr); err != nil {
+				t.Errorf("%s: %v", step.name, err)
+			}
+			if step.name == "cache read" {
+				if err := json.Unmarshal(rr.Body.Bytes(), &items); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		var clients []string
+		for _, item := range items {
+			clients = append(clients, item.ClientID)
+		}
+		if want := []string{"bank-a", "bank-b"}; !reflect.DeepEqual(clients, want) {
+			t.Errorf("expected items of clients %v, got %v", want, clients)
+		}
+	})
+
+	t.Run("Test metrics authentication", func(t *testing.T) {
+		a, err := auth.New([]auth.APIKey{
+			{Key: "exec-key", ClientID: "bank-a", Scopes: []string{auth.ScopeExecute}},
+			{Key: "scrape-key", ClientID: "prometheus", Scopes: []string{auth.ScopeMetricsRead}},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		protected, open := chi.NewRouter(), chi.NewRouter()
+		RegisterMetrics(protected, metrics.Default, a)
+		RegisterMetrics(open, metrics.Default, nil)
+
+		for _, step := range []struct {
+			name       string
+			r          http.Handler
+			key        string
+			wantStatus int
+		}{
+			{"no credentials", protected, "", http.StatusUnauthorized},
+			{"missing scope", protected, "exec-key", http.StatusForbidden},
+			{"scrape key", protected, "scrape-key", http.StatusOK},
+			{"auth disabled", open, "", http.StatusOK},
+		} {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if step.key != "" {
+				req.Header.Set(auth.HeaderAPIKey, step.key)
+			}
+			rr := httptest.NewRecorder()
+			step.r.ServeHTTP(rr, req)
+			if rr.Code != step.wantStatus {
+				t.Errorf("%s: expected status %d, got %d", step.name, step.wantStatus, rr.Code)
+			}
+			if leaked := strings.Contains(rr.Body.String(), "http_requests_total"); leaked != (step.wantStatus == http.StatusOK) {
+				t.Errorf("%s: expected metrics only with status 200, got %d %s", step.name, rr.Code, rr.Body)
+			}
+		}
+	})
+
+	t.Run("Test tenant isolation", func(t *testing.T) {
+		spec, err := loadSpec(openAPI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := auth.New([]auth.APIKey{
+			{Key: "a-key", ClientID: "bank-a", Scopes: auth.Scopes},
+			{Key: "a-mobile", ClientID: "mobile", Tenant: "bank-a", Scopes: auth.Scopes},
+			{Key: "b-key", ClientID: "bank-b", Scopes: auth.Scopes},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		const body = `{"object_cost":1200,"initial_payment":240,"months":12,"program":{"base":true}}`
+		do := func(r chi.Router, method, path, key, tenantID string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set(auth.HeaderAPIKey, key)
+			if tenantID != "" {
+				req.Header.Set(tenant.Header, tenantID)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if err := spec.checkResponse(method, strings.TrimPrefix(path, "/v1"), rr); err != nil {
+				t.Errorf("%s %s: %v", method, path, err)
+			}
+			return rr
+		}
+		clients := func(rr *httptest.ResponseRecorder) []string {
+			var items []service.CacheItem
+			_ = json.Unmarshal(rr.Body.Bytes(), &items) //nolint:errcheck // an error response lists nobody
+			var ids []string
+			for _, item := range items {
+				ids = append(ids, item.ClientID)
+			}
+			return ids
+		}
+
+		r := chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)), WithAuth(a))
+		do(r, "POST", "/v1/execute", "a-key", "")
+		do(r, "POST", "/v1/execute", "b-key", "bank-a")
+		if got := clients(do(r, "GET", "/v1/cache", "a-mobile", "")); !reflect.DeepEqual(got, []string{"bank-a"}) {
+			t.Errorf("expected bank-a to see only its calculation, got %v", got)
+		}
+		if got := clients(do(r, "GET", "/v1/cache", "b-key", "")); !reflect.DeepEqual(got, []string{"bank-b"}) {
+			t.Errorf("expected the untrusted header ignored, got %v", got)
+		}
+
+		r = chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)), WithAuth(a), WithTrustedTenantHeader())
+		do(r, "POST", "/v1/execute", "a-mobile", "bank-a")
+		if got := clients(do(r, "GET", "/v1/cache", "a-key", "")); !reflect.DeepEqual(got, []string{"mobile"}) {
+			t.Errorf("expected a header matching the credentials honoured, got %v", got)
+		}
+		rr := do(r, "GET", "/v1/cache", "b-key", "bank-a")
+		var resp ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); rr.Code != http.StatusForbidden || err != nil || resp.Code != CodeForbidden {
+			t.Errorf("expected a header naming another tenant rejected, got %d %s", rr.Code, rr.Body)
+		}
+		rr = do(r, "GET", "/v1/cache", "a-key", "bank a")
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); rr.Code != http.StatusBadRequest || err != nil || resp.Code != CodeInvalidTenant {
+			t.Errorf("expected an invalid tenant rejected, got %d %s", rr.Code, rr.Body)
+		}
+
+		r = chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)), WithTrustedTenantHeader())
+		do(r, "POST", "/v1/execute", "", "bank-a")
+		if rr := do(r, "GET", "/v1/cache", "", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected the trusted header to choose the tenant without credentials, got %d %s", rr.Code, rr.Body)
+		}
+		if rr := do(r, "GET", "/v1/cache", "", "bank-a"); rr.Code != http.StatusOK {
+			t.Errorf("expected the calculation in bank-a, got %d %s", rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("Test rate limiting", func(t *testing.T) {
+		spec, err := loadSpec(openAPI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := auth.New([]auth.APIKey{
+			{Key: "a-key", ClientID: "bank-a", Scopes: auth.Scopes},
+			{Key: "b-key", ClientID: "bank-b", Scopes: auth.Scopes},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := chi.NewRouter()
+		RegisterRoutes(r, service.New(service.NewStore(service.SequentialIDs(), 0)), WithAuth(a),
+			WithRateLimits(map[string]ratelimit.Limit{
+				"/v1/execute": {Rate: 0.001, Burst: 2},
+				DefaultRoute:  {Rate: 0.001, Burst: 1},
+			}))
+
+		const body = `{"object_cost":1200,"initial_payment":240,"months":12,"program":{"base":true}}`
+		steps := []struct {
+			name, method, path, key string
+			wantStatus              int
+			wantRemaining           string
+		}{
+			{"first", "POST", "/v1/execute", "a-key", http.StatusOK, "1"},
+			{"alias shares the bucket", "POST", "/execute", "a-key", http.StatusOK, "0"},
+			{"exhausted", "POST", "/v1/execute", "a-key", http.StatusTooManyRequests, "0"},
+			{"other client", "POST", "/v1/execute", "b-key", http.StatusOK, "1"},
+			{"anonymous by IP", "POST", "/v1/execute", "", http.StatusUnauthorized, "1"},
+			{"default limit", "GET", "/v1/cache", "a-key", http.StatusOK, "0"},
+			{"default limit exhausted", "GET", "/v1/cache", "a-key", http.StatusTooManyRequests, "0"},
+		}
+		for _, step := range steps {
+			req := httptest.NewRequest(step.method, step.path, strings.NewReader(body))
+			if step.key != "" {
+				req.Header.Set(auth.HeaderAPIKey, step.key)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != step.wantStatus {
+				t.Errorf("%s: expected status %d, got %d %s", step.name, step.wantStatus, rr.Code, rr.Body)
+				continue
+			}
+			if got := rr.Header().Get(HeaderRateLimitRemaining); got != step.wantRemaining {
+				t.Errorf("%s: expected %s remaining, got %q", step.name, step.wantRemaining, got)
+			}
+			if err := spec.checkResponse(step.method, strings.TrimPrefix(step.path, "/v1"), rr); err != nil {
+				t.Errorf("%s: %v", step.name, err)
+			}
+			if rr.Code != http.StatusTooManyRequests {
+				continue
+			}
+			var resp ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Code != CodeRateLimited {
+				t.Errorf("%s: expected code %s, got %s", step.name, CodeRateLimited, rr.Body)
+			}
+			if retry, err := strconv.Atoi(rr.Header().Get("Retry-After")); err != nil || retry < 1 {
+				t.Errorf("%s: expected Retry-After in seconds, got %q", step.name, rr.Header().Get("Retry-After"))
+			}
+		}
+	})
+
+	t.Run("Test in-flight limit", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		h := MaxInFlight(1)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}))
+		done := make(chan int)
+		go func() {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/cache", nil))
+			done <- rr.Code
+		}()
+		<-started
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/cache", nil))
+		var resp ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); rr.Code != http.StatusServiceUnavailable || err != nil ||
+			resp.Code != CodeOverloaded || rr.Header().Get("Retry-After") != "1" {
+			t.Errorf("expected the second request shed, got %d %v %s", rr.Code, rr.Header(), rr.Body)
+		}
+		close(release)
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("expected the first request served, got %d", code)
+		}
+	})
+
+	t.Run("Test idempotency keys", func(t *testing.T) {
+		specs := map[string]apiSpec{}
+		for prefix, doc := range map[string][]byte{"/v1": openAPI, "/v2": openAPIV2} {
+			spec, err := loadSpec(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			specs[prefix] = spec
+		}
+		svc := service.New(service.NewStore(service.SequentialIDs(), 0))
+		r := chi.NewRouter()
+		RegisterRoutes(r, svc, WithIdempotency(idempotency.New(time.Hour)))
+
+		const (
+			body    = `{"object_cost":1200,"initial_payment":240,"months":12,"program":{"base":true}}`
+			other   = `{"object_cost":1200,"initial_payment":240,"months":24,"program":{"base":true}}`
+			invalid = `{"object_cost":1200,"initial_payment":0,"months":12,"program":{"base":true}}`
+		)
+		steps := []struct {
+			name, path, key, body string
+			wantStatus            int
+			wantCode              string
+			wantReplayed          bool
+		}{
+			{"first", "/v1/execute", "k1", body, http.StatusOK, "", false},
+			{"replay on the alias", "/execute", "k1", body, http.StatusOK, "", true},
+			{"different body", "/v1/execute", "k1", other, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, false},
+			{"other route", "/v2/execute", "k1", other, http.StatusOK, "", false},
+			{"invalid key", "/v1/execute", "ключ", body, http.StatusBadRequest, CodeInvalidIdempotencyKey, false},
+			{"client error", "/v1/execute", "k2", invalid, http.StatusBadRequest, CodeInitialPaymentLow, false},
+			{"client error replayed", "/v1/execute", "k2", invalid, http.StatusBadRequest, CodeInitialPaymentLow, true},
+		}
+		var first *httptest.ResponseRecorder
+		for _, step := range steps {
+			req := httptest.NewRequest("POST", step.path, strings.NewReader(step.body))
+			req.Header.Set(HeaderIdempotencyKey, step.key)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != step.wantStatus {
+				t.Errorf("%s: expected status %d, got %d %s", step.name, step.wantStatus, rr.Code, rr.Body)
+				continue
+			}
+			if replayed := rr.Header().Get(HeaderIdempotentReplayed) == "true"; replayed != step.wantReplayed {
+				t.Errorf("%s: expected replayed %v, got %q", step.name, step.wantReplayed, rr.Header().Get(HeaderIdempotentReplayed))
+			}
+			if step.wantCode != "" {
+				var resp ErrorResponse
+				if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Code != step.wantCode {
+					t.Errorf("%s: expected code %s, got %s", step.name, step.wantCode, rr.Body)
+				}
+			}
+			prefix, path := "/v1", strings.TrimPrefix(step.path, "/v1")
+			if strings.HasPrefix(step.path, "/v2") {
+				prefix, path = "/v2", strings.TrimPrefix(step.path, "/v2")
+			}
+			if err := specs[prefix].checkResponse("POST", path, rr); err != nil {
+				t.Errorf("%s: %v", step.name, err)
+			}
+			switch step.name {
+			case "first":
+				first = rr
+			case "replay on the alias":
+				if rr.Body.String() != first.Body.String() || rr.Header().Get(HeaderCacheID) != first.Header().Get(HeaderCacheID) ||
+					rr.Header().Get(HeaderCache) != "MISS" {
+					t.Errorf("expected the first response verbatim, got %v %s", rr.Header(), rr.Body)
+				}
+			}
+		}
+		if got := len(svc.GetAll(context.Background())); got != 2 {
+			t.Errorf("expected 2 calculations stored, got %d", got)
+		}
+	})
+
+	t.Run("Test panic in batch worker", func(t *testing.T) {
+		reqs := []json.RawMessage{json.RawMessage(`{"object_cost":1200,"initial_payment":240,"months":12,"program":{"base":true}}`)}
+		var items []batchItem
+		// A nil service panics on use, standing in for a bug in the calculation.
+		runBatch(context.Background(), nil, reqs, false, 1, func(item batchItem) {
+			items = append(items, item)
+		})
+		if len(items) != 1 || items[0].Status != http.StatusInternalServerError || items[0].Code != CodeInternal {
+			t.Errorf("expected the panic reported as a failed item, got %+v", items)
+		}
+	})
 }
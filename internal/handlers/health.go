@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"sber_test/internal/buildinfo"
+	"sber_test/internal/service"
+	"sber_test/internal/tenant"
+
+	"github.com/go-chi/chi"
+)
+
+// readyTimeout bounds the cache check of one /readyz request.
+const readyTimeout = 2 * time.Second
+
+// Readiness statuses.
+const (
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+	// StatusDraining means the server is shutting down.
+	StatusDraining = "draining"
+)
+
+// Health serves the probe endpoints of the service.
+type Health struct {
+	svc      *service.Service
+	draining atomic.Bool
+	timeout  time.Duration
+}
+
+// NewHealth returns the probes of svc.
+func NewHealth(svc *service.Service) *Health {
+	return &Health{svc: svc, timeout: readyTimeout}
+}
+
+// Drain makes /readyz fail from now on, so that load balancers stop sending
+// requests before the server stops taking them.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// RegisterProbes registers GET /healthz, /readyz and /version. They are not
+// part of the versioned API.
+func RegisterProbes(r chi.Router, h *Health) {
+	r.Get("/healthz", h.Healthz)
+	r.Get("/readyz", h.Readyz)
+	r.Get("/version", h.Version)
+}
+
+// Healthz answers the liveness probe: the process is up and serving.
+func (h *Health) Healthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyResponse is the body of /readyz. Checks maps every check to "ok" or
+// to the reason it failed.
+type ReadyResponse struct {
+	Checks map[string]string `json:"checks"`
+	Status string            `json:"status"`
+}
+
+// Readyz answers the readiness probe: the program catalogues of the service
+// and of every configured tenant load and parse, the caches of all tenants
+// take writes, and the server isn't shutting down.
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	resp := ReadyResponse{Status: StatusReady, Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			resp.Status = StatusNotReady
+			resp.Checks[name] = err.Error()
+			return
+		}
+		resp.Checks[name] = "ok"
+	}
+	check("catalogue", h.svc.CheckCatalogues())
+	check("cache", h.svc.CheckCaches(ctx))
+	if h.draining.Load() {
+		resp.Status = StatusDraining
+	}
+
+	status := http.StatusOK
+	if resp.Status != StatusReady {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, resp)
+}
+
+// VersionResponse is the body of /version.
+type VersionResponse struct {
+	buildinfo.Info
+	// CatalogueVersion is empty when the catalogue can't be loaded.
+	CatalogueVersion string `json:"catalogue_version,omitempty"`
+}
+
+// Version reports the build of the binary and the version of the default
+// program catalogue.
+func (h *Health) Version(w http.ResponseWriter, r *http.Request) {
+	ctx := tenant.With(r.Context(), tenant.Default)
+	version, _ := h.svc.CatalogueVersion(ctx) //nolint:errcheck // reported by /readyz
+	writeJSON(w, http.StatusOK, VersionResponse{Info: buildinfo.Read(), CatalogueVersion: version})
+}
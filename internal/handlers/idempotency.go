@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+
+	"sber_test/internal/auth"
+	"sber_test/internal/idempotency"
+	"sber_test/internal/metrics"
+	"sber_test/internal/tenant"
+)
+
+var idempotentReplays = metrics.Default.NewCounter("calculator_idempotent_replays_total",
+	"Responses replayed for a repeated Idempotency-Key, by route.", "route")
+
+// Idempotency headers. A request carrying HeaderIdempotencyKey is served
+// once; repeating it with the same key and body replays the first response
+// with HeaderIdempotentReplayed set.
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKeyLen bounds the length of an accepted Idempotency-Key.
+const maxIdempotencyKeyLen = 255
+
+// Idempotent is a middleware replaying the first response to requests
+// repeated with the same Idempotency-Key, kept in store. Keys are scoped to
+// the tenant, client and route. The same key with a different body is
+// rejected with 422, and with 409 while the first request is in flight.
+// Server errors are not kept, so the request can be retried. Requests
+// without the header pass through.
+func Idempotent(route string, store *idempotency.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HeaderIdempotencyKey)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !validIdempotencyKey(key) {
+				writeError(w, http.StatusBadRequest, ErrorResponse{
+					Error: "invalid idempotency key",
+					Code:  CodeInvalidIdempotencyKey,
+					Details: map[string]int{
+						"max_length": maxIdempotencyKeyLen,
+					},
+				})
+				return
+			}
+			data, ok := readBody(w, r)
+			if !ok {
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+
+			scoped := tenant.FromContext(r.Context()) + "\x00" + auth.ClientID(r.Context()) + "\x00" + route + "\x00" + key
+			resp, replay, err := store.Begin(scoped, sha256.Sum256(data))
+			switch {
+			case errors.Is(err, idempotency.ErrMismatch):
+				writeError(w, http.StatusUnprocessableEntity, ErrorResponse{
+					Error: "idempotency key reused with a different request",
+					Code:  CodeIdempotencyKeyReused,
+				})
+				return
+			case errors.Is(err, idempotency.ErrInProgress):
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusConflict, ErrorResponse{
+					Error: "request with the idempotency key in progress",
+					Code:  CodeIdempotencyKeyInUse,
+				})
+				return
+			case replay:
+				idempotentReplays.Inc(route)
+				w.Header().Set(HeaderIdempotentReplayed, "true")
+				writeResponse(w, resp)
+				return
+			}
+
+			buf := &bufferedWriter{header: make(http.Header)}
+			completed := false
+			defer func() {
+				if !completed {
+					store.Release(scoped)
+				}
+			}()
+			next.ServeHTTP(buf, r)
+			resp = idempotency.Response{Status: buf.status, Header: buf.header, Body: buf.body.Bytes()}
+			if resp.Status == 0 {
+				resp.Status = http.StatusOK
+			}
+			if resp.Status < http.StatusInternalServerError {
+				store.Complete(scoped, resp)
+				completed = true
+			}
+			writeResponse(w, resp)
+		})
+	}
+}
+
+// validIdempotencyKey accepts 1 to 255 printable ASCII characters.
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	for _, c := range key {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, resp idempotency.Response) {
+	for name, values := range resp.Header {
+		w.Header()[name] = slices.Clone(values)
+	}
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write(resp.Body) //nolint:errcheck // a failed write means the client is gone
+}
+
+// bufferedWriter holds a response until it is complete, so that it can be
+// kept before it is sent.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedWriter) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p) //nolint:wrapcheck // bytes.Buffer doesn't fail
+}
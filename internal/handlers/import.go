@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sber_test/internal/service"
+	"sber_test/internal/xlsx"
+)
+
+const (
+	// maxImportSize caps the size of an uploaded spreadsheet.
+	maxImportSize = 10 << 20
+	// maxImportColumns is the number of leading XLSX columns read; the
+	// header row may start anywhere within them.
+	maxImportColumns = 26
+)
+
+var (
+	errMissingHeader = errors.New("missing header: a row naming the object_cost, initial_payment, months and program columns, " +
+		"or a column of those labels with the values to their right")
+	errInvalidValue = errors.New("invalid value")
+	errTooManyRows  = errors.New("too many rows")
+)
+
+// ImportLimits bounds POST /import.
+type ImportLimits struct {
+	// MaxRows is the maximum number of rows of a spreadsheet, counting the
+	// header and blank rows.
+	MaxRows int
+}
+
+// DefaultImportLimits are used for limits left at zero.
+var DefaultImportLimits = ImportLimits{MaxRows: 1000}
+
+func (l ImportLimits) withDefaults() ImportLimits {
+	if l.MaxRows <= 0 {
+		l.MaxRows = DefaultImportLimits.MaxRows
+	}
+	return l
+}
+
+var programColumns = []string{"salary", "military", "base"}
+
+// importRow is the outcome of one imported spreadsheet row.
+type importRow struct {
+	ID     service.ID `json:"id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+	Code   string     `json:"code,omitempty"`
+	Row    int        `json:"row"`
+	Cached bool       `json:"cached,omitempty"`
+}
+
+type importReport struct {
+	Rows     []importRow `json:"rows"`
+	Imported int         `json:"imported"`
+	Failed   int         `json:"failed"`
+}
+
+// Import runs every row of an uploaded CSV or XLSX file through the
+// calculator and reports the resulting cache IDs or errors per row.
+// The file is sent either as the request body or as the "file" field of a
+// multipart form. It needs a header row naming the object_cost,
+// initial_payment and months columns plus either a program column holding
+// the program name or boolean salary/military/base columns. A sheet laid
+// out like example_golang.xlsx, with those names as labels down one column
+// and the values in the next, is imported as a single calculation.
+// With "Accept: application/x-ndjson" the per-row results are streamed one
+// per line instead of a summary report.
+// Spreadsheets with more than limits.MaxRows rows are rejected with 413.
+func Import(svc *service.Service, limits ImportLimits) http.HandlerFunc {
+	limits = limits.withDefaults()
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := readUpload(w, r)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			requestFailures.Inc(CodeRequestTooLarge)
+			writeError(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error:   "upload too large",
+				Code:    CodeRequestTooLarge,
+				Details: map[string]int64{"max_bytes": tooLarge.Limit},
+			})
+			return
+		case err != nil:
+			writeError(w, http.StatusBadRequest, ErrorResponse{Error: "invalid upload", Code: CodeInvalidUpload})
+			return
+		}
+		rows, err := readSpreadsheet(data, limits)
+		switch {
+		case errors.Is(err, errTooManyRows) || errors.Is(err, xlsx.ErrTooLarge):
+			writeError(w, http.StatusRequestEntityTooLarge, ErrorResponse{
+				Error:   "spreadsheet too large",
+				Code:    CodeImportTooLarge,
+				Details: map[string]int{"max_rows": limits.MaxRows},
+			})
+			return
+		case err != nil:
+			writeError(w, http.StatusBadRequest, ErrorResponse{Error: "unreadable spreadsheet", Code: CodeInvalidUpload})
+			return
+		}
+		cols, records, err := findRecords(rows)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: CodeInvalidUpload})
+			return
+		}
+
+		if wantsNDJSON(r) {
+			out := newNDJSONWriter(w)
+			for _, rec := range records {
+				if r.Context().Err() != nil {
+					return
+				}
+				if err := out.Write(importOne(r.Context(), svc, cols, rec.cells, rec.row)); err != nil {
+					return
+				}
+			}
+			return
+		}
+
+		report := importReport{Rows: []importRow{}}
+		for _, rec := range records {
+			row := importOne(r.Context(), svc, cols, rec.cells, rec.row)
+			if row.Error != "" {
+				report.Failed++
+			} else {
+				report.Imported++
+			}
+			report.Rows = append(report.Rows, row)
+		}
+
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func importOne(ctx context.Context, svc *service.Service, cols importColumns, row []string, num int) importRow {
+	out := importRow{Row: num}
+	req, err := cols.request(row)
+	if err != nil {
+		out.Error, out.Code = err.Error(), CodeInvalidRequest
+		requestFailures.Inc(out.Code)
+		return out
+	}
+	if err := req.Validate(); err != nil {
+		out.Error, out.Code = err.Error(), CodeValidationFailed
+		requestFailures.Inc(out.Code)
+		return out
+	}
+	res, err := svc.Calculate(ctx, req)
+	if err != nil {
+		_, body := classify(ctx, err)
+		out.Error, out.Code = body.Error, body.Code
+		return out
+	}
+	out.ID, out.Cached = res.ID, res.Cached
+	return out
+}
+
+func readUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read body: %w", err)
+		}
+		return data, nil
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("read form file: %w", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("read form file: %w", err)
+	}
+	return data, nil
+}
+
+// readSpreadsheet parses data as XLSX when it is a zip archive and as CSV
+// otherwise. CSV files may use commas or semicolons as separators.
+// Spreadsheets with more than limits.MaxRows rows fail with errTooManyRows
+// or xlsx.ErrTooLarge.
+func readSpreadsheet(data []byte, limits ImportLimits) ([][]string, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		rows, err := xlsx.ReadRows(bytes.NewReader(data), int64(len(data)), xlsx.Limits{
+			MaxRows:    limits.MaxRows,
+			MaxColumns: maxImportColumns,
+			MaxCells:   limits.MaxRows * maxImportColumns,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read xlsx: %w", err)
+		}
+		return rows, nil
+	}
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		cr.Comma = ';'
+	}
+	var rows [][]string
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv: %w", err)
+		}
+		if len(rows) == limits.MaxRows {
+			return nil, fmt.Errorf("read csv: %w", errTooManyRows)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// importColumns maps column names to their position in a row.
+type importColumns map[string]int
+
+// importRecord is the cells of one calculation and the 1-based row number
+// it is reported under.
+type importRecord struct {
+	cells []string
+	row   int
+}
+
+// findRecords locates the header in the first row naming object_cost. If
+// that row holds the other columns too, every non-blank row below it is a
+// record. Otherwise the labels running down from the object_cost cell up to
+// the first blank one are the header of a single record, whose values are
+// in the column to their right.
+func findRecords(rows [][]string) (importColumns, []importRecord, error) {
+	for i, row := range rows {
+		cols := importColumns{}
+		for j, cell := range row {
+			cols[label(cell)] = j
+		}
+		j, ok := cols["object_cost"]
+		if !ok {
+			continue
+		}
+		if cols.has("initial_payment", "months") && cols.hasProgram() {
+			var records []importRecord
+			for k := i + 1; k < len(rows); k++ {
+				if !blank(rows[k]) {
+					records = append(records, importRecord{cells: rows[k], row: k + 1})
+				}
+			}
+			return cols, records, nil
+		}
+		return verticalRecord(rows, i, j)
+	}
+	return nil, nil, errMissingHeader
+}
+
+// verticalRecord reads the label/value block whose first label is at row i,
+// column j.
+func verticalRecord(rows [][]string, i, j int) (importColumns, []importRecord, error) {
+	cols, rec := importColumns{}, importRecord{row: i + 1}
+	for k := i; k < len(rows) && j < len(rows[k]) && label(rows[k][j]) != ""; k++ {
+		cols[label(rows[k][j])] = len(rec.cells)
+		value := ""
+		if j+1 < len(rows[k]) {
+			value = rows[k][j+1]
+		}
+		rec.cells = append(rec.cells, value)
+	}
+	if !cols.has("initial_payment", "months") || !cols.hasProgram() {
+		return nil, nil, errMissingHeader
+	}
+	return cols, []importRecord{rec}, nil
+}
+
+func label(cell string) string {
+	return strings.ToLower(strings.TrimSpace(cell))
+}
+
+func (c importColumns) has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := c[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c importColumns) hasProgram() bool {
+	if c.has("program") {
+		return true
+	}
+	for _, name := range programColumns {
+		if c.has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c importColumns) cell(row []string, name string) string {
+	j, ok := c[name]
+	if !ok || j >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[j])
+}
+
+func (c importColumns) request(row []string) (service.ExecuteRequest, error) {
+	var req service.ExecuteRequest
+	var err error
+	if req.ObjectCost, err = c.float(row, "object_cost"); err != nil {
+		return req, err
+	}
+	if req.InitialPayment, err = c.float(row, "initial_payment"); err != nil {
+		return req, err
+	}
+	months, err := c.float(row, "months")
+	if err != nil {
+		return req, err
+	}
+	if months != math.Trunc(months) {
+		return req, fmt.Errorf("%w for months: %q", errInvalidValue, c.cell(row, "months"))
+	}
+	req.Months = int(months)
+
+	req.Program = map[string]bool{}
+	for _, name := range strings.FieldsFunc(c.cell(row, "program"), func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	}) {
+		req.Program[strings.ToLower(name)] = true
+	}
+	for _, name := range programColumns {
+		if !c.has(name) {
+			continue
+		}
+		v := c.cell(row, name)
+		if v == "" {
+			continue
+		}
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return req, fmt.Errorf("%w for %s: %q", errInvalidValue, name, v)
+		}
+		req.Program[name] = req.Program[name] || on
+	}
+	return req, nil
+}
+
+func (c importColumns) float(row []string, name string) (float64, error) {
+	v := c.cell(row, name)
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%w for %s: %q", errInvalidValue, name, v)
+	}
+	return f, nil
+}
+
+func blank(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sber_test/internal/auth"
+	"sber_test/internal/capture"
+	"sber_test/internal/metrics"
+
+	"github.com/go-chi/chi"
+)
+
+var (
+	httpRequests = metrics.Default.NewCounter("http_requests_total",
+		"HTTP requests by method, route pattern and status.", "method", "route", "status")
+	httpDuration = metrics.Default.NewHistogram("http_request_duration_seconds",
+		"HTTP request latency by method, route pattern and status.", metrics.DefaultBuckets, "method", "route", "status")
+	requestFailures = metrics.Default.NewCounter("calculator_request_failures_total",
+		"Calculation requests rejected as invalid, by error code.", "code")
+)
+
+// unmatchedRoute labels requests that matched no route, so that arbitrary
+// paths don't create new series.
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels requests with a method outside knownMethods, so that
+// made-up verbs don't create new series or span names either.
+const otherMethod = "other"
+
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// methodLabel returns the method of r, or otherMethod if it isn't a known one.
+func methodLabel(r *http.Request) string {
+	if knownMethods[r.Method] {
+		return r.Method
+	}
+	return otherMethod
+}
+
+// Metrics is a middleware recording the count and latency of requests,
+// labelled by the chi route pattern rather than the raw path and by the
+// method, with unknown methods counted as otherMethod. It must be
+// installed on the root router, where the full pattern is known once the
+// request has been routed.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		serveCaptured(w, r, next, func(_ *capture.Writer, status int) {
+			method, route, code := methodLabel(r), routePattern(r), strconv.Itoa(status)
+			httpRequests.Inc(method, route, code)
+			httpDuration.Observe(time.Since(start).Seconds(), method, route, code)
+		})
+	})
+}
+
+// RegisterMetrics registers GET /metrics serving h. Like the API, it takes
+// the credentials checked by a, nil meaning none, and needs the
+// auth.ScopeMetricsRead scope, as the metrics reveal routes, tenants and
+// error rates.
+func RegisterMetrics(r chi.Router, h http.Handler, a *auth.Authenticator) {
+	r.With(Authenticate(a), RequireScope(auth.ScopeMetricsRead)).Method(http.MethodGet, "/metrics", h)
+}
+
+// routePattern returns the chi route pattern r was served by.
+func routePattern(r *http.Request) string {
+	return contextRoute(r.Context())
+}
+
+// contextRoute returns the chi route pattern of the request ctx belongs to.
+func contextRoute(ctx context.Context) string {
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+	return unmatchedRoute
+}
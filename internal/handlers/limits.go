@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sber_test/internal/auth"
+	"sber_test/internal/metrics"
+	"sber_test/internal/ratelimit"
+)
+
+var (
+	rateLimited = metrics.Default.NewCounter("calculator_rate_limited_total",
+		"Requests rejected with 429 for exceeding the rate limit, by route.", "route")
+	shedRequests = metrics.Default.NewCounter("calculator_shed_requests_total",
+		"Requests rejected with 503 because too many were in flight.")
+)
+
+// DefaultRoute names the rate limit of the routes without one of their own
+// in WithRateLimits.
+const DefaultRoute = "*"
+
+// Rate limit headers, after the IETF draft "RateLimit header fields for
+// HTTP": the burst, the requests left in it and the seconds until it is
+// full again.
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+)
+
+// RateLimit is a middleware allowing every client requests at the rate of
+// l. Clients are told apart by their principal, i.e. by API key or JWT, or
+// else by IP. Rejected requests get 429 with Retry-After; every response
+// carries the RateLimit-* headers. route labels the rejections in metrics.
+func RateLimit(route string, l *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := l.Allow(clientKey(r))
+			w.Header().Set(HeaderRateLimitLimit, strconv.Itoa(d.Limit))
+			w.Header().Set(HeaderRateLimitRemaining, strconv.Itoa(d.Remaining))
+			w.Header().Set(HeaderRateLimitReset, seconds(d.Reset))
+			if !d.Allowed {
+				rateLimited.Inc(route)
+				w.Header().Set("Retry-After", seconds(d.RetryAfter))
+				writeError(w, http.StatusTooManyRequests, ErrorResponse{Error: "rate limit exceeded", Code: CodeRateLimited})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey identifies the client of r for rate limiting.
+func clientKey(r *http.Request) string {
+	if id := auth.ClientID(r.Context()); id != "" {
+		return "client:" + id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// seconds formats d as whole seconds, rounded up so that clients waiting
+// that long are never early.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// MaxInFlight is a middleware serving at most n requests at once. Requests
+// over the limit are shed with 503 and "Retry-After: 1" instead of
+// queueing. n must be positive.
+func MaxInFlight(n int) func(http.Handler) http.Handler {
+	slots := make(chan struct{}, n)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+			default:
+				shedRequests.Inc()
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusServiceUnavailable, ErrorResponse{Error: "server overloaded", Code: CodeOverloaded})
+			}
+		})
+	}
+}
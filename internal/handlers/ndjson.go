@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const contentTypeNDJSON = "application/x-ndjson"
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON.
+func wantsNDJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == contentTypeNDJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonWriter streams values one per line, flushing after each, so clients
+// can consume the response incrementally.
+type ndjsonWriter struct {
+	enc *json.Encoder
+	rc  *http.ResponseController
+}
+
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	return &ndjsonWriter{enc: json.NewEncoder(w), rc: http.NewResponseController(w)}
+}
+
+func (n *ndjsonWriter) Write(v any) error {
+	if err := n.enc.Encode(v); err != nil {
+		return fmt.Errorf("encode line: %w", err)
+	}
+	if err := n.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// The OpenAPI 3 documents describing the routes registered by
+// RegisterRoutes, one per API version. Keep them in sync with the handlers;
+// TestHandlers checks real responses against them.
+var (
+	//go:embed openapi.json
+	openAPI []byte
+	//go:embed openapi_v2.json
+	openAPIV2 []byte
+)
+
+// OpenAPI serves the OpenAPI document of the /v1 API.
+func OpenAPI(w http.ResponseWriter, _ *http.Request) {
+	writeSpec(w, openAPI)
+}
+
+// OpenAPIV2 serves the OpenAPI document of the /v2 API.
+func OpenAPIV2(w http.ResponseWriter, _ *http.Request) {
+	writeSpec(w, openAPIV2)
+}
+
+func writeSpec(w http.ResponseWriter, doc []byte) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	_, _ = w.Write(doc) //nolint:errcheck // a failed write means the client is gone
+}
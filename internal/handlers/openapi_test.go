@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// apiSpec checks JSON values against the subset of OpenAPI 3 schema
+// keywords used by openapi.json.
+type apiSpec map[string]any
+
+func loadSpec(doc []byte) (apiSpec, error) {
+	var spec apiSpec
+	if err := json.Unmarshal(doc, &spec); err != nil {
+		return nil, fmt.Errorf("parse OpenAPI document: %w", err)
+	}
+	return spec, nil
+}
+
+// lookup follows a path of keys through the document.
+func (s apiSpec) lookup(keys ...string) (map[string]any, bool) {
+	cur := map[string]any(s)
+	for _, k := range keys {
+		next, ok := cur[k].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		cur = next
+	}
+	return cur, true
+}
+
+func (s apiSpec) resolve(node map[string]any) (map[string]any, error) {
+	ref, ok := node["$ref"].(string)
+	if !ok {
+		return node, nil
+	}
+	target, ok := s.lookup(strings.Split(strings.TrimPrefix(ref, "#/"), "/")...)
+	if !ok {
+		return nil, fmt.Errorf("unresolved $ref %s", ref)
+	}
+	return s.resolve(target)
+}
+
+// checkResponse validates the body recorded in rr against the schema the
+// spec gives for method, path and the recorded status and content type.
+// NDJSON bodies are validated line by line.
+func (s apiSpec) checkResponse(method, path string, rr *httptest.ResponseRecorder) error {
+	status := strconv.Itoa(rr.Code)
+	resp, ok := s.lookup("paths", path, strings.ToLower(method), "responses", status)
+	if !ok {
+		return fmt.Errorf("%s %s: status %s not documented", method, path, status)
+	}
+	resp, err := s.resolve(resp)
+	if err != nil {
+		return err
+	}
+	mediaType, _, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	schema, ok := apiSpec(resp).lookup("content", mediaType, "schema")
+	if !ok {
+		return fmt.Errorf("%s %s: %s response with %s not documented", method, path, status, mediaType)
+	}
+
+	bodies := [][]byte{rr.Body.Bytes()}
+	if mediaType == contentTypeNDJSON {
+		bodies = bytes.Split(bytes.TrimSpace(rr.Body.Bytes()), []byte("\n"))
+	}
+	for _, body := range bodies {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return fmt.Errorf("%s %s: invalid JSON %q: %w", method, path, body, err)
+		}
+		if err := s.validate(schema, v, ""); err != nil {
+			return fmt.Errorf("%s %s %s: %w", method, path, status, err)
+		}
+	}
+	return nil
+}
+
+//nolint:gocyclo // one case per schema keyword
+func (s apiSpec) validate(schema map[string]any, v any, at string) error {
+	schema, err := s.resolve(schema)
+	if err != nil {
+		return err
+	}
+	if alts, ok := schema["oneOf"].([]any); ok {
+		matched := 0
+		for _, alt := range alts {
+			if s.validate(alt.(map[string]any), v, at) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: %v matches %d of the oneOf schemas", at, v, matched)
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || fmt.Sprint(e) == fmt.Sprint(v)
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, v, enum)
+		}
+	}
+
+	typ, _ := schema["type"].(string)
+	switch typ {
+	case "":
+		return nil
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string, got %T", at, v)
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			return fmt.Errorf("%s: %q doesn't match %s", at, str, pattern)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", at, v)
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected %s, got %T", at, typ, v)
+		}
+		if _, err := n.Int64(); typ == "integer" && err != nil {
+			return fmt.Errorf("%s: expected integer, got %s", at, n)
+		}
+		f, _ := n.Float64() //nolint:errcheck // json.Number is always a valid float
+		if minimum, ok := schema["minimum"].(float64); ok && f < minimum {
+			return fmt.Errorf("%s: %s is below %g", at, n, minimum)
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", at, v)
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for i, item := range items {
+			if err := s.validate(itemSchema, item, at+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", at, v)
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required %s", at, name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for name, value := range obj {
+			if prop, ok := props[name].(map[string]any); ok {
+				if err := s.validate(prop, value, at+"/"+name); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: undocumented property %s", at, name)
+				}
+			case map[string]any:
+				if err := s.validate(extra, value, at+"/"+name); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unsupported schema type %s", at, typ)
+	}
+	return nil
+}
+
+// operations lists the "METHOD path" pairs documented in the spec.
+func (s apiSpec) operations() []string {
+	paths, _ := s.lookup("paths")
+	var ops []string
+	for path, item := range paths {
+		for method := range item.(map[string]any) {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	return ops
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"sber_test/internal/service"
+)
+
+// Programs serves the program catalogue of the caller's tenant.
+func Programs(svc *service.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		programs, err := svc.Catalogue(r.Context())
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, programs)
+	}
+}
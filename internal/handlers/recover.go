@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"sber_test/internal/capture"
+	"sber_test/internal/logging"
+	"sber_test/internal/metrics"
+)
+
+var panics = metrics.Default.NewCounter("calculator_panics_total",
+	"Panics recovered while serving requests, by route pattern.", "route")
+
+// Recoverer is a middleware turning a panic in a handler into a JSON 500
+// carrying the request ID. The panic and its stack trace are logged. If the
+// response had already been started, the connection is aborted instead so
+// the client doesn't take a truncated body for a complete one.
+//
+// It must be installed after RequestID, Trace, Logger and Metrics so that
+// those see the 500 rather than the panic.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := capture.Wrap(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler { //nolint:errorlint,goerr113 // sentinel passed to panic as is
+				panic(p)
+			}
+			reportPanic(r.Context(), p)
+			if cw.Written() || cw.Hijacked() {
+				panic(http.ErrAbortHandler)
+			}
+			writeError(cw, http.StatusInternalServerError, ErrorResponse{
+				Error:     "internal error",
+				Code:      CodeInternal,
+				RequestID: logging.RequestID(r.Context()),
+			})
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// reportPanic logs a recovered panic with the current stack and counts it.
+// It must be called from the deferred function that recovered p.
+func reportPanic(ctx context.Context, p any) {
+	route := contextRoute(ctx)
+	panics.Inc(route)
+	slog.ErrorContext(ctx, "panic recovered",
+		"panic", fmt.Sprint(p),
+		"route", route,
+		"stack", string(debug.Stack()),
+	)
+}
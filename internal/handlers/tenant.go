@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"sber_test/internal/auth"
+	"sber_test/internal/tenant"
+	"sber_test/internal/tracing"
+)
+
+// Tenant is a middleware putting the tenant of the request into its
+// context: the tenant of its principal or, when trustHeader is set and the
+// principal has none, the one named by the X-Tenant-ID header. An invalid
+// header is rejected with 400, and one naming a tenant other than the
+// principal's with 403; without trustHeader the header is ignored.
+// Requests without a principal or header act for tenant.Default.
+func Tenant(trustHeader bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, _ := auth.FromContext(r.Context())
+			id := p.Tenant
+			if header := r.Header.Get(tenant.Header); trustHeader && header != "" {
+				if !tenant.Valid(header) {
+					writeError(w, http.StatusBadRequest, ErrorResponse{
+						Error: "invalid tenant",
+						Code:  CodeInvalidTenant,
+						Details: map[string]string{
+							"header": tenant.Header,
+						},
+					})
+					return
+				}
+				if id != tenant.Default && header != id {
+					writeError(w, http.StatusForbidden, ErrorResponse{
+						Error: "tenant doesn't match the credentials",
+						Code:  CodeForbidden,
+						Details: map[string]string{
+							"header": tenant.Header,
+						},
+					})
+					return
+				}
+				id = header
+			}
+			if id != tenant.Default {
+				tracing.SpanFromContext(r.Context()).SetAttributes(tracing.String("tenant", id))
+			}
+			next.ServeHTTP(w, r.WithContext(tenant.With(r.Context(), id)))
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+
+	"sber_test/internal/capture"
+	"sber_test/internal/tracing"
+)
+
+// Trace is a middleware starting a span for every request. A traceparent
+// header sent by the client makes it a child of the client's span, and the
+// span is returned in the traceparent response header. Like Metrics, it
+// names the span after the chi route pattern and so belongs on the root
+// router; it must come before Logger for the trace ID to reach the logs.
+func Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if remote, ok := tracing.Extract(r.Header); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, remote)
+		}
+		method := methodLabel(r)
+		ctx, span := tracing.Start(ctx, method,
+			tracing.String("http.method", method),
+			tracing.String("http.target", r.URL.Path),
+		)
+		tracing.Inject(ctx, w.Header())
+
+		r = r.WithContext(ctx)
+		serveCaptured(w, r, next, func(_ *capture.Writer, status int) {
+			route := routePattern(r)
+			span.SetName(method + " " + route)
+			span.SetAttributes(tracing.String("http.route", route), tracing.Int("http.status_code", status))
+			if status >= http.StatusInternalServerError {
+				span.RecordError(errorStatus(status))
+			}
+			span.End()
+		})
+	})
+}
+
+// errorStatus is an HTTP status recorded as a span error.
+type errorStatus int
+
+func (e errorStatus) Error() string {
+	return http.StatusText(int(e))
+}
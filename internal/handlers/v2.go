@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sber_test/internal/service"
+)
+
+// Decimal is an exact decimal amount encoded as a JSON string, so clients
+// don't have to round binary floats themselves.
+type Decimal string
+
+// money formats an amount with two decimal places.
+func money(v float64) Decimal {
+	return Decimal(strconv.FormatFloat(math.Round(v*100)/100, 'f', 2, 64))
+}
+
+// percent formats a rate in percent exactly as the catalogue gives it, with
+// at least two decimal places: 8 is "8.00" and 7.125 is "7.125".
+func percent(v float64) Decimal {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	return Decimal(whole + "." + frac)
+}
+
+// ParamsV2 echoes the calculation parameters.
+type ParamsV2 struct {
+	ObjectCost     Decimal `json:"object_cost"`
+	InitialPayment Decimal `json:"initial_payment"`
+	Months         int     `json:"months"`
+}
+
+// AggregatesV2 holds the results of a calculation.
+type AggregatesV2 struct {
+	// Rate is the annual rate in percent.
+	Rate            Decimal `json:"rate"`
+	LoanSum         Decimal `json:"loan_sum"`
+	MonthlyPayment  Decimal `json:"monthly_payment"`
+	Overpayment     Decimal `json:"overpayment"`
+	LastPaymentDate string  `json:"last_payment_date"`
+}
+
+// InstallmentV2 is one monthly payment of the repayment schedule.
+type InstallmentV2 struct {
+	Number    int     `json:"number"`
+	Date      string  `json:"date"`
+	Payment   Decimal `json:"payment"`
+	Principal Decimal `json:"principal"`
+	Interest  Decimal `json:"interest"`
+	Balance   Decimal `json:"balance"`
+}
+
+// CalculationV2 is a calculation in the /v2 format.
+type CalculationV2 struct {
+	ID         service.ID      `json:"id"`
+	Program    string          `json:"program"`
+	Params     ParamsV2        `json:"params"`
+	Aggregates AggregatesV2    `json:"aggregates"`
+	Schedule   []InstallmentV2 `json:"schedule,omitempty"`
+	Cached     bool            `json:"cached,omitempty"`
+	// ClientID is set in cache listings; see service.CacheItem.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// ExecuteV2 is Execute answering in the /v2 format. With ?schedule=true the
+// response includes the repayment schedule.
+func ExecuteV2(svc *service.Service) http.HandlerFunc {
+	return execute(svc, func(r *http.Request, res service.Result) (any, error) {
+		out, err := toV2(r, res.ID, res.Response)
+		out.Cached = res.Cached
+		return out, err
+	})
+}
+
+// GetCacheV2 is GetCache answering in the /v2 format. With ?schedule=true
+// every item includes its repayment schedule.
+func GetCacheV2(svc *service.Service) http.HandlerFunc {
+	return getCache(svc, func(r *http.Request, item service.CacheItem) (any, error) {
+		out, err := toV2(r, item.ID, item.ExecuteResponse)
+		out.ClientID = item.ClientID
+		return out, err
+	})
+}
+
+// toV2 adapts a calculation to the /v2 format.
+func toV2(r *http.Request, id service.ID, resp service.ExecuteResponse) (CalculationV2, error) {
+	a := resp.Aggregates
+	out := CalculationV2{
+		ID:      id,
+		Program: resp.ProgramName(),
+		Params: ParamsV2{
+			ObjectCost:     money(resp.Params.ObjectCost),
+			InitialPayment: money(resp.Params.InitialPayment),
+			Months:         resp.Params.Months,
+		},
+		Aggregates: AggregatesV2{
+			Rate:            percent(a.Rate),
+			LoanSum:         money(a.LoanSum),
+			MonthlyPayment:  money(a.MonthlyPayment),
+			Overpayment:     money(a.Overpayment),
+			LastPaymentDate: a.LastPaymentDate,
+		},
+	}
+	if withSchedule, _ := strconv.ParseBool(r.URL.Query().Get("schedule")); !withSchedule { //nolint:errcheck // absent or invalid means false
+		return out, nil
+	}
+	schedule, err := service.Schedule(resp)
+	if err != nil {
+		return out, fmt.Errorf("schedule of item %s: %w", id, err)
+	}
+	out.Schedule = make([]InstallmentV2, len(schedule))
+	for i, inst := range schedule {
+		out.Schedule[i] = InstallmentV2{
+			Number:    inst.Number,
+			Date:      inst.Date,
+			Payment:   money(inst.Payment),
+			Principal: money(inst.Principal),
+			Interest:  money(inst.Interest),
+			Balance:   money(inst.Balance),
+		}
+	}
+	return out, nil
+}
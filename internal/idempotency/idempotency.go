@@ -0,0 +1,193 @@
+// Package idempotency remembers the responses to requests sent with an
+// idempotency key, so that clients retrying a request get the first
+// response instead of repeating its effects.
+package idempotency
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Errors returned by Begin.
+var (
+	// ErrInProgress means a request with the key is still being served.
+	ErrInProgress = errors.New("request with the idempotency key in progress")
+	// ErrMismatch means the key was used for a different request.
+	ErrMismatch = errors.New("idempotency key used for a different request")
+)
+
+// Fingerprint identifies the contents of a request.
+type Fingerprint [sha256.Size]byte
+
+// Response is a response as it was first sent.
+type Response struct {
+	Status int
+	// Header holds the headers set by the handler, e.g. the ID of the cache
+	// item created.
+	Header http.Header
+	Body   []byte
+}
+
+// sweepInterval is how often expired responses are dropped.
+const sweepInterval = time.Minute
+
+type entry struct {
+	fingerprint Fingerprint
+	done        bool
+	resp        Response
+	expires     time.Time
+	// elem is the key's place in Store.order.
+	elem *list.Element
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.done && !now.Before(e.expires)
+}
+
+// Store keeps responses by key for a window after they are sent.
+type Store struct {
+	ttl         time.Duration
+	now         func() time.Time
+	maxEntries  int
+	maxBodySize int
+	mu          sync.Mutex
+	entries     map[string]*entry
+	// order holds the keys oldest claim first.
+	order     *list.List
+	evictions uint64
+	lastSweep time.Time
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithClock makes the store take the time from now.
+func WithClock(now func() time.Time) Option {
+	return func(s *Store) {
+		s.now = now
+	}
+}
+
+// WithMaxEntries bounds the store to n keys, evicting the oldest claim when
+// a new key is claimed. Zero or less means unlimited.
+func WithMaxEntries(n int) Option {
+	return func(s *Store) {
+		s.maxEntries = n
+	}
+}
+
+// WithMaxBodySize makes the store drop responses with bodies over n bytes
+// instead of keeping them. Zero or less means unlimited.
+func WithMaxBodySize(n int) Option {
+	return func(s *Store) {
+		s.maxBodySize = n
+	}
+}
+
+// New returns a Store keeping responses for ttl.
+func New(ttl time.Duration, opts ...Option) *Store {
+	s := &Store{ttl: ttl, now: time.Now, entries: make(map[string]*entry), order: list.New()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.lastSweep = s.now()
+	return s
+}
+
+// Begin claims key for a request with fingerprint fp. If a request with
+// the key was completed within the window, its response is returned with
+// replay set. It returns ErrMismatch if the key was used with another
+// fingerprint and ErrInProgress if the first request is still being
+// served. A successful claim must be followed by Complete or Release.
+func (s *Store) Begin(key string, fp Fingerprint) (resp Response, replay bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	e, ok := s.entries[key]
+	switch {
+	case !ok || e.expired(now):
+		if ok {
+			s.deleteLocked(key, e)
+		}
+		s.entries[key] = &entry{fingerprint: fp, elem: s.order.PushBack(key)}
+		for s.maxEntries > 0 && len(s.entries) > s.maxEntries {
+			oldest := s.order.Front().Value.(string) //nolint:forcetypeassert // order only holds keys
+			s.deleteLocked(oldest, s.entries[oldest])
+			s.evictions++
+		}
+		return Response{}, false, nil
+	case e.fingerprint != fp:
+		return Response{}, false, ErrMismatch
+	case !e.done:
+		return Response{}, false, ErrInProgress
+	}
+	return e.resp, true, nil
+}
+
+// Complete stores resp as the response to the request that claimed key.
+// A response with a body over the WithMaxBodySize limit is not kept and the
+// key is released instead.
+func (s *Store) Complete(key string, resp Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	switch {
+	case !ok || e.done:
+	case s.maxBodySize > 0 && len(resp.Body) > s.maxBodySize:
+		s.deleteLocked(key, e)
+	default:
+		e.done, e.resp, e.expires = true, resp, s.now().Add(s.ttl)
+	}
+}
+
+// Release gives up the claim on key without storing a response, so that
+// the request may be retried.
+func (s *Store) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && !e.done {
+		s.deleteLocked(key, e)
+	}
+}
+
+func (s *Store) deleteLocked(key string, e *entry) {
+	delete(s.entries, key)
+	s.order.Remove(e.elem)
+}
+
+// sweep drops expired responses, at most once per sweepInterval.
+func (s *Store) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if e.expired(now) {
+			s.deleteLocked(key, e)
+		}
+	}
+}
+
+// Len returns the number of keys kept.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// Evictions returns the number of keys evicted to stay within the limit.
+func (s *Store) Evictions() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.evictions
+}
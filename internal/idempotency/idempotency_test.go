@@ -0,0 +1,92 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Date(2024, 2, 18, 10, 0, 0, 0, time.UTC)
+	s := New(time.Hour, WithClock(func() time.Time { return now }))
+	body, other := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b"))
+
+	if _, replay, err := s.Begin("k", body); replay || err != nil {
+		t.Fatalf("expected the key claimed, got %v, %v", replay, err)
+	}
+	if _, _, err := s.Begin("k", body); !errors.Is(err, ErrInProgress) {
+		t.Errorf("expected ErrInProgress, got %v", err)
+	}
+	want := Response{Status: http.StatusOK, Header: http.Header{"X-Cache-Id": {"0"}}, Body: []byte(`{}`)}
+	s.Complete("k", want)
+	if resp, replay, err := s.Begin("k", body); !replay || err != nil || !reflect.DeepEqual(resp, want) {
+		t.Errorf("expected the response replayed, got %+v, %v, %v", resp, replay, err)
+	}
+	if _, _, err := s.Begin("k", other); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, got %v", err)
+	}
+
+	if _, _, err := s.Begin("released", body); err != nil {
+		t.Fatal(err)
+	}
+	s.Release("released")
+	if _, replay, err := s.Begin("released", other); replay || err != nil {
+		t.Errorf("expected a released key claimable again, got %v, %v", replay, err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, replay, err := s.Begin("k", other); replay || err != nil {
+		t.Errorf("expected the key expired, got %v, %v", replay, err)
+	}
+	if got := s.Len(); got != 2 {
+		t.Errorf("expected the in-progress keys kept, got %d", got)
+	}
+}
+
+func TestStoreLimits(t *testing.T) {
+	now := time.Date(2024, 2, 18, 10, 0, 0, 0, time.UTC)
+	s := New(24*time.Hour, WithClock(func() time.Time { return now }), WithMaxEntries(2), WithMaxBodySize(4))
+	body := sha256.Sum256([]byte("a"))
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, _, err := s.Begin(key, body); err != nil {
+			t.Fatal(err)
+		}
+		s.Complete(key, Response{Status: http.StatusOK, Body: []byte("ok")})
+	}
+	if got := s.Len(); got != 2 {
+		t.Errorf("expected 2 keys kept, got %d", got)
+	}
+	if got := s.Evictions(); got != 1 {
+		t.Errorf("expected 1 eviction, got %d", got)
+	}
+	if _, replay, _ := s.Begin("a", body); replay {
+		t.Error("expected the oldest key evicted")
+	}
+	if _, replay, _ := s.Begin("c", body); !replay {
+		t.Error("expected the newest key kept")
+	}
+
+	s.Complete("a", Response{Status: http.StatusOK, Body: []byte("too long")})
+	if _, replay, err := s.Begin("a", body); replay || err != nil {
+		t.Errorf("expected a response over the body limit dropped, got %v, %v", replay, err)
+	}
+
+	s = New(24*time.Hour, WithClock(func() time.Time { return now }))
+	for _, step := range []struct {
+		key     string
+		advance time.Duration
+	}{{"k", time.Hour}, {"x", 23 * time.Hour}, {"y", time.Hour}} {
+		now = now.Add(step.advance)
+		if _, _, err := s.Begin(step.key, body); err != nil {
+			t.Fatal(err)
+		}
+		s.Complete(step.key, Response{Status: http.StatusOK})
+	}
+	if got := s.Len(); got != 2 {
+		t.Errorf("expected expired keys swept within sweepInterval, got %d keys", got)
+	}
+}
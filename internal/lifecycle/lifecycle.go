@@ -0,0 +1,65 @@
+// Package lifecycle stops the long-running components of the application in
+// a defined order when it shuts down.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrStopped is returned by Add after Shutdown has begun.
+var ErrStopped = errors.New("lifecycle: already shut down")
+
+type component struct {
+	name string
+	stop func(context.Context) error
+}
+
+// Manager stops the components added to it in reverse order of addition,
+// like deferred calls: a component that depends on another one is added
+// after it and therefore stopped before it. For example the HTTP server is
+// added last, so it stops taking requests before the cache it writes to is
+// flushed.
+type Manager struct {
+	mu         sync.Mutex
+	components []component
+	stopped    bool
+}
+
+// New returns an empty Manager.
+func New() *Manager {
+	return &Manager{}
+}
+
+// Add registers stop to be called with the shutdown context when the
+// Manager shuts down.
+func (m *Manager) Add(name string, stop func(context.Context) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return ErrStopped
+	}
+	m.components = append(m.components, component{name: name, stop: stop})
+	return nil
+}
+
+// Shutdown stops every component, the last added first. Each one is stopped
+// even if an earlier one failed or ctx expired, so that nothing that can
+// still be flushed is lost; the errors are joined. Later calls do nothing.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	components := m.components
+	m.components, m.stopped = nil, true
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+		if err := c.stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,55 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestShutdownStopsInReverseOrder(t *testing.T) {
+	m := New()
+	var order []string
+	errFlush := errors.New("disk full")
+	for _, name := range []string{"store", "janitor", "server"} {
+		name := name
+		if err := m.Add(name, func(context.Context) error {
+			order = append(order, name)
+			if name == "janitor" {
+				return errFlush
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := m.Shutdown(context.Background())
+	if !errors.Is(err, errFlush) {
+		t.Errorf("expected the janitor error, got %v", err)
+	}
+	if want := []string{"server", "janitor", "store"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("expected stop order %v, got %v", want, order)
+	}
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Errorf("expected a second shutdown to do nothing, got %v", err)
+	}
+	if err := m.Add("late", func(context.Context) error { return nil }); !errors.Is(err, ErrStopped) {
+		t.Errorf("expected ErrStopped, got %v", err)
+	}
+}
+
+func TestShutdownPassesContext(t *testing.T) {
+	m := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	_ = m.Add("server", func(ctx context.Context) error { //nolint:errcheck // the manager is fresh
+		called = true
+		return ctx.Err()
+	})
+	if err := m.Shutdown(ctx); !errors.Is(err, context.Canceled) || !called {
+		t.Errorf("expected the component to see the cancelled context, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+// Package logging sets up structured logging and carries the request ID
+// through contexts into log records.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"sber_test/internal/tracing"
+)
+
+// Formats accepted by New.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	// FormatConsole prints the access line mandated by the task spec,
+	// "2022/02/17 19:26:52 status_code: 200, duration: 243042 ns", and other
+	// records as the message followed by key=value attributes.
+	FormatConsole = "console"
+)
+
+// Access log records have AccessMessage as message and carry the status and
+// duration under StatusKey and DurationKey.
+const (
+	AccessMessage = "request"
+	StatusKey     = "status"
+	DurationKey   = "duration"
+	// RequestIDKey is the attribute holding the request ID.
+	RequestIDKey = "request_id"
+	// TraceIDKey is the attribute holding the ID of the current trace.
+	TraceIDKey = "trace_id"
+)
+
+// New returns a logger writing records of at least level to w in format.
+// Every record logged with a context carrying a request ID or a span gets
+// them as the request_id and trace_id attributes.
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	case FormatConsole:
+		h = &consoleHandler{out: &lockedWriter{w: w}, level: level}
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request and trace IDs from the context to records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(RequestIDKey, id))
+	}
+	if sc := tracing.SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		r.AddAttrs(slog.String(TraceIDKey, sc.TraceID.String()))
+	}
+	return h.Handler.Handle(ctx, r) //nolint:wrapcheck // transparent wrapper
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) write(p []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(p)
+	return err //nolint:wrapcheck // reported by slog
+}
+
+// consoleHandler writes records in the format of the standard log package.
+type consoleHandler struct {
+	out    *lockedWriter
+	level  slog.Level
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	b.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+
+	var status, duration slog.Value
+	attrs := append([]slog.Attr(nil), h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case StatusKey:
+			status = a.Value
+		case DurationKey:
+			duration = a.Value
+		}
+		a.Key = h.prefix + a.Key
+		attrs = append(attrs, a)
+		return true
+	})
+
+	if r.Message == AccessMessage && status.Kind() == slog.KindInt64 && duration.Kind() == slog.KindDuration {
+		fmt.Fprintf(&b, "status_code: %d, duration: %d ns\n", status.Int64(), duration.Duration().Nanoseconds())
+		return h.out.write(b.Bytes())
+	}
+
+	if r.Level != slog.LevelInfo {
+		b.WriteString(r.Level.String())
+		b.WriteByte(' ')
+	}
+	b.WriteString(r.Message)
+	for _, a := range attrs {
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value.Resolve())
+	}
+	b.WriteByte('\n')
+	return h.out.write(b.Bytes())
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"sber_test/internal/tracing"
+)
+
+func TestConsoleFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatConsole, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithRequestID(context.Background(), "abc")
+	logger.InfoContext(ctx, AccessMessage, slog.String("method", "POST"), slog.Int(StatusKey, 200), slog.Duration(DurationKey, 243042*time.Nanosecond))
+	logger.With("component", "service").WarnContext(ctx, "catalogue unavailable", "err", "no file")
+	logger.Debug("hidden")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	access := regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} status_code: 200, duration: 243042 ns$`)
+	if !access.MatchString(lines[0]) {
+		t.Errorf("expected the spec access line, got %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], " WARN catalogue unavailable component=service err=no file request_id=abc") {
+		t.Errorf("unexpected line %q", lines[1])
+	}
+}
+
+func TestJSONFormatCarriesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON, slog.LevelDebug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := tracing.New(nil).Start(WithRequestID(context.Background(), "abc"), "calculate")
+	defer span.End()
+	logger.DebugContext(ctx, "calculated", "program", "base")
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec[RequestIDKey] != "abc" || rec[TraceIDKey] != span.SpanContext().TraceID.String() || rec["program"] != "base" || rec["level"] != "DEBUG" {
+		t.Errorf("unexpected record %v", rec)
+	}
+
+	if _, err := New(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests served.", "route", "status")
+	latency := r.NewHistogram("latency_seconds", "Request latency.\nIn seconds.", []float64{0.1, 1}, "route")
+	r.NewGaugeFunc("items", "Cached items.", func() float64 { return 3 })
+
+	requests.Inc("/execute", "200")
+	requests.Inc("/execute", "200")
+	requests.Add(0.5, `/a"b\c`, "400")
+	latency.Observe(0.05, "/execute")
+	latency.Observe(0.5, "/execute")
+	latency.Observe(2, "/execute")
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP items Cached items.
+# TYPE items gauge
+items 3
+# HELP latency_seconds Request latency.\nIn seconds.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/execute",le="0.1"} 1
+latency_seconds_bucket{route="/execute",le="1"} 2
+latency_seconds_bucket{route="/execute",le="+Inf"} 3
+latency_seconds_sum{route="/execute"} 2.55
+latency_seconds_count{route="/execute"} 3
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a\"b\\c",status="400"} 0.5
+requests_total{route="/execute",status="200"} 2
+`
+	if b.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b.String())
+	}
+	if requests.Value("/execute", "200") != 2 || latency.Count("/execute") != 3 {
+		t.Error("expected Value and Count to report the recorded series")
+	}
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Header().Get("Content-Type") != ContentType || rr.Body.String() != want {
+		t.Errorf("expected the handler to serve the same output, got %q", rr.Body.String())
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("total", "", "code")
+	for name, fn := range map[string]func(){
+		"duplicate":       func() { r.NewCounter("total", "") },
+		"label count":     func() { c.Inc() },
+		"negative add":    func() { c.Add(-1, "x") },
+		"unsorted bucket": func() { r.NewHistogram("h", "", []float64{1, 0.5}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
@@ -0,0 +1,170 @@
+// Package ratelimit limits how often clients may call a route with token
+// buckets: every client may send Burst requests at once and then Rate
+// requests per second.
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is the rate of a token bucket.
+type Limit struct {
+	// Rate is the number of requests per second refilled into the bucket.
+	Rate float64
+	// Burst is the size of the bucket, the most requests allowed at once.
+	Burst int
+}
+
+// fillTime returns how long an empty bucket takes to fill up.
+func (l Limit) fillTime() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// Decision is the outcome of Allow.
+type Decision struct {
+	Allowed bool
+	// Limit is the burst of the bucket and Remaining the requests left in it.
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed; zero if
+	// Allowed.
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// elem is the key's place in Limiter.order.
+	elem *list.Element
+}
+
+// Limiter keeps a token bucket per client key. Buckets that have filled up
+// again are dropped from time to time, so idle clients cost no memory, and
+// WithMaxKeys bounds the buckets kept for clients that are still active.
+type Limiter struct {
+	limit   Limit
+	now     func() time.Time
+	maxKeys int
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// order holds the keys least recently used first.
+	order     *list.List
+	evictions uint64
+	lastSweep time.Time
+}
+
+// Option configures a Limiter.
+type Option func(*Limiter)
+
+// WithClock makes the limiter take the time from now.
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// WithMaxKeys bounds the limiter to n buckets, evicting the least recently
+// used one when a new key arrives. An evicted client starts over with a
+// full bucket. Zero or less means unlimited.
+func WithMaxKeys(n int) Option {
+	return func(l *Limiter) {
+		l.maxKeys = n
+	}
+}
+
+// New returns a Limiter allowing every key requests at limit. The rate and
+// burst of limit must be positive.
+func New(limit Limit, opts ...Option) *Limiter {
+	l := &Limiter{limit: limit, now: time.Now, buckets: make(map[string]*bucket), order: list.New()}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.lastSweep = l.now()
+	return l
+}
+
+// Allow takes a token from the bucket of key if there is one.
+func (l *Limiter) Allow(key string) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if ok {
+		l.order.MoveToBack(b.elem)
+	} else {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now, elem: l.order.PushBack(key)}
+		l.buckets[key] = b
+		for l.maxKeys > 0 && len(l.buckets) > l.maxKeys {
+			oldest := l.order.Front().Value.(string) //nolint:forcetypeassert // order only holds keys
+			l.deleteLocked(oldest, l.buckets[oldest])
+			l.evictions++
+		}
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	d := Decision{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = l.duration(1 - b.tokens)
+	}
+	d.Remaining = int(math.Floor(b.tokens))
+	d.Reset = l.duration(float64(l.limit.Burst) - b.tokens)
+	return d
+}
+
+// refill returns the tokens in b at now.
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.limit.Rate
+	return math.Min(tokens, float64(l.limit.Burst))
+}
+
+// duration returns how long refilling tokens takes.
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / l.limit.Rate * float64(time.Second)))
+}
+
+// sweep drops full buckets, at most once per fill time. A dropped bucket
+// is recreated full, so dropping it changes nothing.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.limit.fillTime() {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Burst) {
+			l.deleteLocked(key, b)
+		}
+	}
+}
+
+func (l *Limiter) deleteLocked(key string, b *bucket) {
+	l.order.Remove(b.elem)
+	delete(l.buckets, key)
+}
+
+// Len returns the number of buckets kept.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.buckets)
+}
+
+// Evictions returns the number of buckets evicted to stay within
+// WithMaxKeys.
+func (l *Limiter) Evictions() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.evictions
+}
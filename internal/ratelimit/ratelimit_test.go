@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Date(2024, 2, 18, 10, 0, 0, 0, time.UTC)
+	l := New(Limit{Rate: 2, Burst: 3}, WithClock(func() time.Time { return now }))
+
+	for i := 2; i >= 0; i-- {
+		d := l.Allow("a")
+		if !d.Allowed || d.Remaining != i || d.Limit != 3 {
+			t.Fatalf("expected request allowed with %d remaining, got %+v", i, d)
+		}
+	}
+	d := l.Allow("a")
+	if d.Allowed || d.Remaining != 0 || d.RetryAfter != 500*time.Millisecond || d.Reset != 1500*time.Millisecond {
+		t.Errorf("expected the empty bucket to deny, got %+v", d)
+	}
+	if d := l.Allow("b"); !d.Allowed {
+		t.Errorf("expected other keys unaffected, got %+v", d)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if d := l.Allow("a"); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected a token refilled, got %+v", d)
+	}
+	now = now.Add(time.Hour)
+	if d := l.Allow("a"); !d.Allowed || d.Remaining != 2 {
+		t.Errorf("expected the bucket capped at the burst, got %+v", d)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Date(2024, 2, 18, 10, 0, 0, 0, time.UTC)
+	l := New(Limit{Rate: 1, Burst: 10}, WithClock(func() time.Time { return now }))
+	l.Allow("idle")
+	now = now.Add(9 * time.Second)
+	for i := 0; i < 10; i++ {
+		l.Allow("busy")
+	}
+	now = now.Add(2 * time.Second)
+	l.Allow("new")
+	if got := l.Len(); got != 2 {
+		t.Errorf("expected the idle bucket dropped, got %d buckets", got)
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	now := time.Date(2024, 2, 18, 10, 0, 0, 0, time.UTC)
+	l := New(Limit{Rate: 0.001, Burst: 2}, WithClock(func() time.Time { return now }), WithMaxKeys(2))
+	l.Allow("a")
+	l.Allow("a")
+	l.Allow("b")
+	if d := l.Allow("a"); d.Allowed {
+		t.Fatalf("expected a's bucket empty, got %+v", d)
+	}
+	for i := 0; i < 100; i++ {
+		now = now.Add(time.Millisecond)
+		l.Allow(fmt.Sprintf("10.0.0.%d", i))
+	}
+	if got := l.Len(); got != 2 {
+		t.Errorf("expected at most 2 buckets, got %d", got)
+	}
+	if got := l.Evictions(); got != 100 {
+		t.Errorf("expected 100 evictions, got %d", got)
+	}
+
+	l = New(Limit{Rate: 0.001, Burst: 1}, WithClock(func() time.Time { return now }), WithMaxKeys(2))
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("a")
+	l.Allow("c")
+	if d := l.Allow("a"); d.Allowed {
+		t.Errorf("expected the recently used bucket kept over b's, got %+v", d)
+	}
+}
@@ -1,37 +1,232 @@
 // Package cache provides a simple in-memory cache.
 package cache
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
 
-// Cache stores data in memory with thread-safe operations.
-type Cache struct {
-	data []interface{}
-	mu   sync.Mutex
+// probeInterval is how often Probe retries taking the write lock.
+const probeInterval = 5 * time.Millisecond
+
+// ErrProbeKey is returned by Probe when its key holds a stored value.
+var ErrProbeKey = errors.New("cache: probe key in use")
+
+// Cache stores typed values in insertion order with thread-safe operations.
+//
+// Reads are optimised: values are kept in an append-only slice, so GetAll
+// hands out a length-capped view of it under a shared lock instead of
+// copying. Operations that would rewrite existing elements (Delete, or Add
+// with an existing key) build a fresh slice, leaving earlier snapshots intact.
+// Evicting the oldest entry only reslices, so index holds positions counted
+// from the first entry ever stored and base is the position of keys[0].
+type Cache[K comparable, V any] struct {
+	mu        sync.RWMutex
+	keys      []K
+	values    []V
+	index     map[K]int
+	base      int
+	maxItems  int
+	evictions uint64
+	nextID    func() K
+}
+
+// Option configures a Cache.
+type Option[K comparable, V any] func(*Cache[K, V])
+
+// WithIDs makes the cache allocate keys for Insert with next.
+// next is always called under the cache's write lock.
+func WithIDs[K comparable, V any](next func() K) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.nextID = next
+	}
+}
+
+// WithMaxItems bounds the cache to n entries, evicting the oldest when a new
+// one is stored. Zero or less means unlimited.
+func WithMaxItems[K comparable, V any](n int) Option[K, V] {
+	return func(c *Cache[K, V]) {
+		c.maxItems = n
+	}
 }
 
 // New creates a new Cache instance.
-func New() *Cache {
-	return &Cache{
-		data: make([]interface{}, 0),
+func New[K comparable, V any](opts ...Option[K, V]) *Cache[K, V] {
+	c := &Cache[K, V]{
+		index: make(map[K]int),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Insert allocates a new key, stores the value built for it and returns the
+// key. Allocation and storage happen atomically, so build can embed the key
+// in the value. Insert panics if the cache was created without WithIDs.
+func (c *Cache[K, V]) Insert(build func(key K) V) K {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.nextID == nil {
+		panic("cache: Insert requires WithIDs")
+	}
+	key := c.nextID()
+	for {
+		if _, taken := c.index[key]; !taken {
+			break
+		}
+		key = c.nextID()
 	}
+	c.appendLocked(key, build(key))
+	return key
 }
 
-// Add adds an item to the cache and returns its ID.
-func (c *Cache) Add(item interface{}) int {
+// Add stores value under key. An existing value with the same key is
+// replaced in place, keeping its position.
+func (c *Cache[K, V]) Add(key K, value V) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	id := len(c.data)
-	c.data = append(c.data, item)
-	return id
+	if i, ok := c.index[key]; ok {
+		values := make([]V, len(c.values))
+		copy(values, c.values)
+		values[i-c.base] = value
+		c.values = values
+		return
+	}
+	c.appendLocked(key, value)
+}
+
+// appendLocked stores a new entry and evicts the oldest ones over the limit.
+func (c *Cache[K, V]) appendLocked(key K, value V) {
+	c.index[key] = c.base + len(c.keys)
+	c.keys = append(c.keys, key)
+	c.values = append(c.values, value)
+	for c.maxItems > 0 && len(c.keys) > c.maxItems {
+		delete(c.index, c.keys[0])
+		c.keys, c.values = c.keys[1:], c.values[1:]
+		c.base++
+		c.evictions++
+	}
+}
+
+// Evictions returns the number of entries evicted to stay within the limit.
+func (c *Cache[K, V]) Evictions() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.evictions
+}
+
+// Probe checks that the cache takes writes: it stores key and deletes it
+// again under the write lock, without evicting anything. It fails if the
+// lock can't be taken before ctx is done, which only happens when a writer
+// holds it for too long, or if key is in use. Probe polls for the lock
+// rather than waiting on it, so a stuck cache leaves nothing behind.
+func (c *Cache[K, V]) Probe(ctx context.Context, key K) error {
+	if !c.mu.TryLock() {
+		ticker := time.NewTicker(probeInterval)
+		defer ticker.Stop()
+		for !c.mu.TryLock() {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("cache: not writable: %w", ctx.Err())
+			case <-ticker.C:
+			}
+		}
+	}
+	defer c.mu.Unlock()
+
+	if _, taken := c.index[key]; taken {
+		return ErrProbeKey
+	}
+	c.index[key] = -1
+	delete(c.index, key)
+	return nil
 }
 
-// GetAll retrieves all items from the cache.
-func (c *Cache) GetAll() []interface{} {
+// IDs returns the key generator set with WithIDs, or nil if there is none.
+func (c *Cache[K, V]) IDs() func() K {
+	return c.nextID
+}
+
+// MaxItems returns the limit set with WithMaxItems, or 0 if there is none.
+func (c *Cache[K, V]) MaxItems() int {
+	return c.maxItems
+}
+
+// Get returns the value stored under key.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	i, ok := c.index[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return c.values[i-c.base], true
+}
+
+// Delete removes the value stored under key and reports whether it existed.
+func (c *Cache[K, V]) Delete(key K) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	out := make([]interface{}, len(c.data))
-	copy(out, c.data)
-	return out
+	i, ok := c.index[key]
+	if !ok {
+		return false
+	}
+	i -= c.base
+	keys := make([]K, 0, len(c.keys)-1)
+	keys = append(keys, c.keys[:i]...)
+	keys = append(keys, c.keys[i+1:]...)
+	values := make([]V, 0, len(c.values)-1)
+	values = append(values, c.values[:i]...)
+	values = append(values, c.values[i+1:]...)
+
+	delete(c.index, key)
+	for j := i; j < len(keys); j++ {
+		c.index[keys[j]] = c.base + j
+	}
+	c.keys, c.values = keys, values
+	return true
+}
+
+// Len returns the number of stored values.
+func (c *Cache[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.values)
+}
+
+// Range calls fn for every entry in insertion order until fn returns false.
+// It iterates over a snapshot, so fn may safely modify the cache.
+func (c *Cache[K, V]) Range(fn func(key K, value V) bool) {
+	keys, values := c.snapshot()
+	for i := range values {
+		if !fn(keys[i], values[i]) {
+			return
+		}
+	}
+}
+
+// GetAll returns a snapshot of all values in insertion order.
+// The returned slice is shared with the cache and must not be modified.
+func (c *Cache[K, V]) GetAll() []V {
+	_, values := c.snapshot()
+	return values
+}
+
+func (c *Cache[K, V]) snapshot() ([]K, []V) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	n := len(c.values)
+	return c.keys[:n:n], c.values[:n:n]
 }
@@ -1,29 +1,258 @@
 package cache
 
 import (
+	"context"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestAddAndGetAll(t *testing.T) {
-	c := New()
+	c := New[int, string]()
 	item := "Test item"
-	id := c.Add(item)
+	c.Add(0, item)
 	items := c.GetAll()
-	assert.Greater(t, id, -1)
 	assert.NotEmpty(t, items)
 	assert.Contains(t, items, item)
 }
 
 func TestAddMultipleItems(t *testing.T) {
-	c := New()
+	c := New[int, string]()
 	item1 := "Test item 1"
 	item2 := "Test item 2"
-	id1 := c.Add(item1)
-	id2 := c.Add(item2)
+	c.Add(0, item1)
+	c.Add(1, item2)
 	items := c.GetAll()
-	assert.Contains(t, items, item1)
-	assert.Contains(t, items, item2)
-	assert.NotEqual(t, id1, id2)
+	assert.Equal(t, []string{item1, item2}, items)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestGet(t *testing.T) {
+	c := New[string, int]()
+	c.Add("a", 1)
+
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+}
+
+func TestAddReplacesExistingKey(t *testing.T) {
+	c := New[int, string]()
+	c.Add(0, "a")
+	c.Add(1, "b")
+	before := c.GetAll()
+
+	c.Add(0, "c")
+	assert.Equal(t, []string{"c", "b"}, c.GetAll())
+	assert.Equal(t, []string{"a", "b"}, before, "earlier snapshots must not change")
+}
+
+func TestDelete(t *testing.T) {
+	c := New[int, string]()
+	c.Add(0, "a")
+	c.Add(1, "b")
+	c.Add(2, "c")
+	before := c.GetAll()
+
+	assert.True(t, c.Delete(1))
+	assert.False(t, c.Delete(1))
+	assert.Equal(t, []string{"a", "c"}, c.GetAll())
+	assert.Equal(t, []string{"a", "b", "c"}, before, "earlier snapshots must not change")
+
+	v, ok := c.Get(2)
+	assert.True(t, ok)
+	assert.Equal(t, "c", v)
+}
+
+func TestRange(t *testing.T) {
+	c := New[int, string]()
+	c.Add(0, "a")
+	c.Add(1, "b")
+	c.Add(2, "c")
+
+	var keys []int
+	c.Range(func(k int, _ string) bool {
+		keys = append(keys, k)
+		return k < 1
+	})
+	assert.Equal(t, []int{0, 1}, keys)
+}
+
+func TestSnapshotIsStableUnderAppend(t *testing.T) {
+	c := New[int, int]()
+	c.Add(0, 0)
+	snap := c.GetAll()
+	c.Add(1, 1)
+	assert.Len(t, snap, 1)
+
+	snap = append(snap, 42)
+	assert.Equal(t, []int{0, 1}, c.GetAll(), "appending to a snapshot must not leak into the cache")
+	assert.Len(t, snap, 2)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	c := New[int, int]()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.Add(w*100+i, i)
+				_ = c.GetAll()
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.Equal(t, 800, c.Len())
+}
+
+func TestInsert(t *testing.T) {
+	next := 0
+	c := New[int, string](WithIDs[int, string](func() int {
+		next++
+		return next
+	}))
+	c.Add(2, "taken")
+
+	id1 := c.Insert(func(id int) string { return "a" })
+	id2 := c.Insert(func(id int) string { return "b" })
+	assert.Equal(t, 1, id1)
+	assert.Equal(t, 3, id2, "Insert should skip keys already in use")
+	assert.Equal(t, []string{"taken", "a", "b"}, c.GetAll())
+}
+
+func TestInsertConcurrent(t *testing.T) {
+	next := 0
+	c := New[int, int](WithIDs[int, int](func() int {
+		next++
+		return next
+	}))
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.Insert(func(id int) int { return id })
+			}
+		}()
+	}
+	wg.Wait()
+	c.Range(func(k, v int) bool {
+		assert.Equal(t, k, v, "value built for a key should embed that key")
+		return true
+	})
+	assert.Equal(t, 800, c.Len())
+}
+
+func TestInsertWithoutIDs(t *testing.T) {
+	c := New[int, int]()
+	assert.Panics(t, func() { c.Insert(func(id int) int { return id }) })
+}
+
+func TestProbe(t *testing.T) {
+	c := New[int, int](WithMaxItems[int, int](2))
+	c.Add(1, 1)
+	c.Add(2, 2)
+	assert.NoError(t, c.Probe(context.Background(), 0))
+	assert.Equal(t, []int{1, 2}, c.GetAll(), "Probe should neither store nor evict")
+	assert.Equal(t, uint64(0), c.Evictions())
+	assert.ErrorIs(t, c.Probe(context.Background(), 1), ErrProbeKey)
+
+	c.mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.Probe(ctx, 0), context.DeadlineExceeded, "Probe should give up while a writer holds the cache")
+	c.mu.Unlock()
+	assert.NoError(t, c.Probe(context.Background(), 0))
+}
+
+func filled(n int) *Cache[int, int] {
+	c := New[int, int]()
+	for i := 0; i < n; i++ {
+		c.Add(i, i)
+	}
+	return c
+}
+
+func TestMaxItemsEvictsOldest(t *testing.T) {
+	c := New[int, string](WithMaxItems[int, string](2))
+	c.Add(1, "one")
+	c.Add(2, "two")
+	before := c.GetAll()
+	c.Add(3, "three")
+
+	assert.Equal(t, []string{"two", "three"}, c.GetAll())
+	assert.Equal(t, []string{"one", "two"}, before, "snapshots taken before eviction must not change")
+	_, ok := c.Get(1)
+	assert.False(t, ok, "evicted key")
+	v, _ := c.Get(3)
+	assert.Equal(t, "three", v)
+
+	c.Add(2, "TWO")
+	assert.Equal(t, []string{"TWO", "three"}, c.GetAll(), "replacing keeps the position")
+	assert.True(t, c.Delete(2))
+	c.Add(4, "four")
+	c.Add(5, "five")
+	assert.Equal(t, []string{"four", "five"}, c.GetAll())
+	v, _ = c.Get(4)
+	assert.Equal(t, "four", v)
+	assert.Equal(t, 2, c.MaxItems())
+	assert.Equal(t, uint64(2), c.Evictions())
+}
+
+func BenchmarkGetAll(b *testing.B) {
+	c := filled(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = c.GetAll()
+	}
+}
+
+func BenchmarkGetAllParallel(b *testing.B) {
+	c := filled(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = c.GetAll()
+		}
+	})
+}
+
+func BenchmarkGetAllWithWriter(b *testing.B) {
+	c := filled(10000)
+	done := make(chan struct{})
+	go func() {
+		for i := 10000; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				c.Add(i, i)
+			}
+		}
+	}()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = c.GetAll()
+	}
+	b.StopTimer()
+	close(done)
+}
+
+func BenchmarkAdd(b *testing.B) {
+	c := New[int, int]()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Add(i, i)
+	}
 }
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// catalogues caches parsed program catalogues by path. A file is parsed
+// again only when its modification time or size changes, so calculations
+// cost a stat instead of a read.
+type catalogues struct {
+	mu      sync.Mutex
+	entries map[string]catalogueEntry
+}
+
+type catalogueEntry struct {
+	modTime   time.Time
+	size      int64
+	catalogue Catalogue
+}
+
+func newCatalogues() *catalogues {
+	return &catalogues{entries: make(map[string]catalogueEntry)}
+}
+
+// load returns the catalogue at path, parsing it if it changed since the
+// last load. Failures are not cached.
+func (c *catalogues) load(path string) (Catalogue, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return Catalogue{}, fmt.Errorf("%w: unable to get absolute path: %w", ErrCatalogueUnavailable, err)
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return Catalogue{}, fmt.Errorf("%w: unable to read %s: %w", ErrCatalogueUnavailable, path, err)
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[absPath]
+	c.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.catalogue, nil
+	}
+
+	programs, err := loadCatalogue(path)
+	if err != nil {
+		return Catalogue{}, err
+	}
+	c.mu.Lock()
+	c.entries[absPath] = catalogueEntry{modTime: info.ModTime(), size: info.Size(), catalogue: programs}
+	c.mu.Unlock()
+	return programs, nil
+}
@@ -12,4 +12,24 @@ var (
 	ErrChooseOnlyOneProgram    = fmt.Errorf("choose only 1 program")
 	ErrInitialPaymentLow       = fmt.Errorf("the initial payment should be more")
 	ErrFirstPaymentExceedsLoan = errors.New("first payment exceeds loan sum")
+	// ErrCatalogueUnavailable wraps failures to load the program catalogue.
+	ErrCatalogueUnavailable = errors.New("program catalogue unavailable")
+	// ErrMalformedRequest is returned for request bodies that aren't a JSON object.
+	ErrMalformedRequest = errors.New("malformed request")
+	// ErrValidation is wrapped by *ValidationError.
+	ErrValidation = errors.New("validation failed")
 )
+
+// UnknownProgramError reports a requested program that is not offered.
+type UnknownProgramError struct {
+	Name string
+}
+
+func (e *UnknownProgramError) Error() string {
+	return ErrUnknownProgram.Error() + ": " + e.Name
+}
+
+// Unwrap makes errors.Is(err, ErrUnknownProgram) hold.
+func (e *UnknownProgramError) Unwrap() error {
+	return ErrUnknownProgram
+}
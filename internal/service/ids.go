@@ -0,0 +1,120 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sber_test/internal/repo/cache"
+)
+
+// ID identifies a cached calculation. Sequential IDs are encoded in JSON as
+// numbers, as the spec requires; opaque IDs are encoded as strings.
+type ID string
+
+// MarshalJSON implements json.Marshaler.
+func (id ID) MarshalJSON() ([]byte, error) {
+	if id.numeric() {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Both numbers and strings are accepted.
+func (id *ID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = ID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid id %s: %w", data, err)
+	}
+	*id = ID(n)
+	return nil
+}
+
+func (id ID) numeric() bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Store is the cache holding calculations.
+type Store = cache.Cache[ID, CacheItem]
+
+// NewStore creates a Store allocating IDs with next. A maxItems above zero
+// bounds the store, evicting the oldest calculations first.
+func NewStore(next func() ID, maxItems int) *Store {
+	return cache.New[ID, CacheItem](
+		cache.WithIDs[ID, CacheItem](next),
+		cache.WithMaxItems[ID, CacheItem](maxItems),
+	)
+}
+
+// SequentialIDs returns a generator of monotonic integer IDs starting at 0.
+func SequentialIDs() func() ID {
+	var n atomic.Int64
+	return func() ID {
+		return ID(strconv.FormatInt(n.Add(1)-1, 10))
+	}
+}
+
+// TimeOrderedIDs returns a generator of UUIDv7 IDs (RFC 9562). IDs from one
+// generator sort lexically in creation order, including within a millisecond.
+func TimeOrderedIDs() func() ID {
+	var (
+		mu     sync.Mutex
+		lastMS int64
+		seq    uint16
+	)
+	return func() ID {
+		mu.Lock()
+		ms := time.Now().UnixMilli()
+		if ms <= lastMS {
+			ms = lastMS
+			seq++
+			if seq > 0x0fff {
+				ms++
+				seq = 0
+			}
+		} else {
+			seq = 0
+		}
+		lastMS = ms
+		s := seq
+		mu.Unlock()
+
+		var b [16]byte
+		binary.BigEndian.PutUint64(b[:8], uint64(ms)<<16)
+		b[6] = 0x70 | byte(s>>8)
+		b[7] = byte(s)
+		_, _ = rand.Read(b[8:]) //nolint:errcheck // crypto/rand.Read never fails
+		b[8] = b[8]&0x3f | 0x80
+
+		var out [36]byte
+		hex.Encode(out[0:8], b[0:4])
+		out[8] = '-'
+		hex.Encode(out[9:13], b[4:6])
+		out[13] = '-'
+		hex.Encode(out[14:18], b[6:8])
+		out[18] = '-'
+		hex.Encode(out[19:23], b[8:10])
+		out[23] = '-'
+		hex.Encode(out[24:36], b[10:16])
+		return ID(out[:])
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// memoKey is the normalised form of a calculation request. Programs switched
+// off in the request do not influence the result and are dropped.
+type memoKey struct {
+	Programs       []string `json:"programs"`
+	ObjectCost     float64  `json:"object_cost"`
+	InitialPayment float64  `json:"initial_payment"`
+	Months         int      `json:"months"`
+	Version        string   `json:"version"`
+	StartDate      string   `json:"start_date"`
+	ClientID       string   `json:"client_id,omitempty"`
+}
+
+// fingerprint returns a content address for req calculated with the given
+// program catalogue version on the given start date for the given client.
+// Clients never reuse each other's calculations.
+func fingerprint(req ExecuteRequest, version string, start time.Time, clientID string) string {
+	key := memoKey{
+		Programs:       make([]string, 0, len(req.Program)),
+		ObjectCost:     req.ObjectCost,
+		InitialPayment: req.InitialPayment,
+		Months:         req.Months,
+		Version:        version,
+		StartDate:      start.Format(dateLayout),
+		ClientID:       clientID,
+	}
+	for name, on := range req.Program {
+		if on {
+			key.Programs = append(key.Programs, name)
+		}
+	}
+	sort.Strings(key.Programs)
+
+	// memoKey holds only strings and numbers, so Marshal cannot fail.
+	data, _ := json.Marshal(key) //nolint:errcheck // see above
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (t *tenantState) lookup(key string) (Result, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.lookupLocked(key)
+}
+
+func (t *tenantState) lookupLocked(key string) (Result, bool) {
+	id, ok := t.memo.Get(key)
+	if !ok {
+		return Result{}, false
+	}
+	item, ok := t.store.Get(id)
+	if !ok {
+		t.memo.Delete(key)
+		return Result{}, false
+	}
+	return Result{Response: item.ExecuteResponse, ID: id, Cached: true}, true
+}
+
+// save adds resp made by the client to the cache under key, unless an
+// identical calculation finished concurrently, in which case that one is
+// returned.
+func (t *tenantState) save(key string, resp ExecuteResponse, clientID string) Result {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if res, ok := t.lookupLocked(key); ok {
+		return res
+	}
+	id := t.store.Insert(func(id ID) CacheItem {
+		return CacheItem{
+			ID:              id,
+			ExecuteResponse: resp,
+			ClientID:        clientID,
+		}
+	})
+	t.memo.Add(key, id)
+	return Result{Response: resp, ID: id}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Installment is one monthly payment of a loan.
+type Installment struct {
+	Date      string  `json:"date"`
+	Number    int     `json:"number"`
+	Payment   float64 `json:"payment"`
+	Principal float64 `json:"principal"`
+	Interest  float64 `json:"interest"`
+	Balance   float64 `json:"balance"`
+}
+
+// Schedule returns the monthly repayment schedule of a calculated loan.
+// Interest is rounded to kopecks each month and the last installment
+// settles the remaining balance, so principals add up to the loan sum.
+// Installment i falls i months after the calculation date, on the last day
+// of the month when it is shorter. Responses that didn't come from
+// Calculate, e.g. decoded from JSON, don't know that date, so their
+// installments fall on the day of the month of the last payment.
+func Schedule(resp ExecuteResponse) ([]Installment, error) {
+	last, err := time.Parse(dateLayout, resp.Aggregates.LastPaymentDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid last payment date: %w", err)
+	}
+	n := resp.Params.Months
+	start := resp.start
+	if start.IsZero() {
+		start = addMonths(last, -n)
+	}
+	r := resp.Aggregates.Rate / 12.0 / 100.0
+	balance := resp.Aggregates.LoanSum
+
+	out := make([]Installment, 0, n)
+	for i := 1; i <= n; i++ {
+		interest := roundMoney(balance * r)
+		principal := roundMoney(resp.Aggregates.MonthlyPayment - interest)
+		if i == n || principal > balance {
+			principal = balance
+		}
+		balance = roundMoney(balance - principal)
+		out = append(out, Installment{
+			Number:    i,
+			Date:      addMonths(start, i).Format(dateLayout),
+			Payment:   roundMoney(principal + interest),
+			Principal: principal,
+			Interest:  interest,
+			Balance:   balance,
+		})
+	}
+	return out, nil
+}
+
+// ProgramName returns the name of the selected program.
+func (r ExecuteResponse) ProgramName() string {
+	names := make([]string, 0, len(r.Program))
+	for name, on := range r.Program {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// addMonths returns t moved by n months, on the last day of the month if
+// it is shorter than the day of t, where time.AddDate would overflow into
+// the next month.
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	if last := time.Date(y, m+time.Month(n)+1, 0, 0, 0, 0, 0, time.UTC).Day(); d > last {
+		d = last
+	}
+	return time.Date(y, m+time.Month(n), d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100.0
+}
@@ -2,23 +2,92 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"math"
 	"os"
 	"path/filepath"
-	"sber_test/internal/repo/cache"
+	"sber_test/internal/auth"
+	"sber_test/internal/metrics"
+	"sber_test/internal/tenant"
+	"sber_test/internal/tracing"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const dateLayout = "2006-01-02"
+
+var (
+	calculations = metrics.Default.NewCounter("calculator_calculations_total",
+		"Successful calculations by program and whether an earlier result was reused.", "program", "cached")
+	catalogueLoads = metrics.Default.NewCounter("calculator_catalogue_loads_total",
+		"Program catalogue loads by result, success or failure.", "result")
 )
 
-// Service handles loan calculations and caching.
+// DefaultProgramsFile is the program catalogue read unless WithProgramsFile
+// says otherwise.
+const DefaultProgramsFile = "programs.json"
+
+// Service handles loan calculations and caching. Calculations are cached
+// per tenant; see WithTenant.
 type Service struct {
-	cache *cache.Cache
+	ids        func() ID
+	maxItems   int
+	configs    map[string]TenantConfig
+	maxTenants int
+	tenantIdle time.Duration
+	tenantsMu  sync.RWMutex
+	tenants    map[string]*tenantState
+	// droppedTenants and droppedEvictions count the tenants dropped by the
+	// tenant limits and the evictions of their stores.
+	droppedTenants   uint64
+	droppedEvictions uint64
+	now              func() time.Time
+	programsFile     string
+	catalogues       *catalogues
 }
 
-// New creates a new Service instance.
-func New(c *cache.Cache) *Service {
-	return &Service{cache: c}
+// Option configures a Service.
+type Option func(*Service)
+
+// WithProgramsFile sets the path of the program catalogue.
+func WithProgramsFile(path string) Option {
+	return func(s *Service) {
+		s.programsFile = path
+	}
+}
+
+// New creates a new Service instance. c holds the calculations of the
+// default tenant; the stores of other tenants are created on demand with
+// the ID generator and limit of c, so the generator must be safe for
+// concurrent use, as SequentialIDs and TimeOrderedIDs are.
+func New(c *Store, opts ...Option) *Service {
+	s := &Service{
+		ids:          c.IDs(),
+		maxItems:     c.MaxItems(),
+		configs:      make(map[string]TenantConfig),
+		tenants:      make(map[string]*tenantState),
+		now:          time.Now,
+		programsFile: DefaultProgramsFile,
+		catalogues:   newCatalogues(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	programsFile := s.configs[tenant.Default].ProgramsFile
+	if programsFile == "" {
+		programsFile = s.programsFile
+	}
+	s.tenants[tenant.Default] = newTenantState(c, programsFile)
+	return s
 }
 
 // ExecuteRequest contains parameters for loan calculation.
@@ -32,7 +101,7 @@ type ExecuteRequest struct {
 // Aggregates holds the results of loan calculations.
 type Aggregates struct {
 	LastPaymentDate string  `json:"last_payment_date"`
-	Rate            int     `json:"rate"`
+	Rate            float64 `json:"rate"`
 	LoanSum         float64 `json:"loan_sum"`
 	MonthlyPayment  float64 `json:"monthly_payment"`
 	Overpayment     float64 `json:"overpayment"`
@@ -47,78 +116,147 @@ type ExecuteResponse struct {
 		InitialPayment float64 `json:"initial_payment"`
 		Months         int     `json:"months"`
 	} `json:"params"`
+	// start is the date of the calculation, which Schedule counts the
+	// installments from.
+	start time.Time
 }
 
 // CacheItem stores the loan calculation result and its ID.
 type CacheItem struct {
 	ExecuteResponse
-	ID int `json:"id"`
+	ID ID `json:"id"`
+	// ClientID is the authenticated client that made the calculation, or
+	// "" when authentication is disabled.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// Catalogue is the parsed contents of programs.json: the annual rate of
+// every program in percent, which may be fractional, such as 7.5. Files
+// named *.yml or *.yaml are read as YAML with the same keys. A catalogue
+// without a version is versioned by the hash of its contents.
+type Catalogue struct {
+	Version string             `json:"version" yaml:"version"`
+	Rates   map[string]float64 `json:"program_rates" yaml:"program_rates"`
 }
 
-func loadProgramRates() (map[string]int, error) {
-	absPath, err := filepath.Abs("programs.json")
+func loadCatalogue(path string) (Catalogue, error) {
+	absPath, err := filepath.Abs(path)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get absolute path: %w", err)
+		return Catalogue{}, fmt.Errorf("%w: unable to get absolute path: %w", ErrCatalogueUnavailable, err)
 	}
 
 	cleanPath := filepath.Clean(absPath)
 
 	data, err := os.ReadFile(cleanPath)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read programs.json: %w", err)
+		return Catalogue{}, fmt.Errorf("%w: unable to read %s: %w", ErrCatalogueUnavailable, path, err)
 	}
 
-	var result map[string]map[string]int
-	err = json.Unmarshal(data, &result)
-	if err != nil {
-		return nil, fmt.Errorf("error unmarshalling JSON: %w", err)
+	var result Catalogue
+	switch strings.ToLower(filepath.Ext(cleanPath)) {
+	case ".yml", ".yaml":
+		if err := yaml.UnmarshalStrict(data, &result); err != nil {
+			return Catalogue{}, fmt.Errorf("%w: error unmarshalling YAML: %w", ErrCatalogueUnavailable, err)
+		}
+	default:
+		if err := json.Unmarshal(data, &result); err != nil {
+			return Catalogue{}, fmt.Errorf("%w: error unmarshalling JSON: %w", ErrCatalogueUnavailable, err)
+		}
+	}
+	for name, rate := range result.Rates {
+		if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+			return Catalogue{}, fmt.Errorf("%w: invalid rate for program %s in %s: %v", ErrCatalogueUnavailable, name, path, rate)
+		}
+	}
+	if result.Version == "" {
+		sum := sha256.Sum256(data)
+		result.Version = hex.EncodeToString(sum[:8])
 	}
 
-	return result["program_rates"], nil
+	return result, nil
+}
+
+// Result is the outcome of Calculate.
+type Result struct {
+	Response ExecuteResponse
+	ID       ID
+	// Cached reports whether an identical earlier calculation was reused.
+	Cached bool
+}
+
+// Catalogue loads the program catalogue of the tenant ctx acts for. The
+// error says why it can't be loaded or parsed. The catalogue is shared with
+// later loads and must not be modified.
+func (s *Service) Catalogue(ctx context.Context) (Catalogue, error) {
+	return s.catalogues.load(s.tenant(ctx).programsFile)
+}
+
+// CatalogueVersion loads the program catalogue of the tenant ctx acts for
+// and returns its version.
+func (s *Service) CatalogueVersion(ctx context.Context) (string, error) {
+	programs, err := s.Catalogue(ctx)
+	return programs.Version, err
 }
 
 // Execute - adding and calculating new credit.
-func (s *Service) Execute(req ExecuteRequest) (ExecuteResponse, int, error) {
-	programRates, err := loadProgramRates()
+func (s *Service) Execute(req ExecuteRequest) (ExecuteResponse, ID, error) {
+	res, err := s.Calculate(context.Background(), req)
 	if err != nil {
-		return ExecuteResponse{}, 0, err
-	}
-	chosen := 0
-	var annualRate int
-	validPrograms := map[string]struct{}{
-		"salary":   {},
-		"military": {},
-		"base":     {},
-	}
-	for k, v := range req.Program {
-		if _, ok := validPrograms[k]; !ok {
-			return ExecuteResponse{}, 0, fmt.Errorf("%w: %s", ErrUnknownProgram, k)
-		}
-		if v {
-			chosen++
-			switch k {
-			case "salary":
-				annualRate = programRates["salary"]
-			case "military":
-				annualRate = programRates["military"]
-			case "base":
-				annualRate = programRates["base"]
-			}
-		}
+		return ExecuteResponse{}, "", err
 	}
-	if req.InitialPayment >= req.ObjectCost {
-		return ExecuteResponse{}, 0, fmt.Errorf("%w: %f >= %f", ErrFirstPaymentExceedsLoan, req.InitialPayment, req.ObjectCost)
-	}
-	if chosen == 0 {
-		return ExecuteResponse{}, 0, ErrChooseProgram
+	return res.Response, res.ID, nil
+}
+
+// Calculate validates req and returns its calculation. Requests identical to
+// an earlier one of the same client (same parameters, program catalogue
+// version and start date) reuse the cached item instead of creating a new
+// one. ctx carries request-scoped values: the request ID into logs, the
+// authenticated client into the cache item and the tenant whose cache and
+// program catalogue are used.
+func (s *Service) Calculate(ctx context.Context, req ExecuteRequest) (Result, error) {
+	return s.calculate(ctx, req, true)
+}
+
+// Preview calculates req like Calculate but never writes to the cache.
+// An identical cached calculation is still reused.
+func (s *Service) Preview(ctx context.Context, req ExecuteRequest) (Result, error) {
+	return s.calculate(ctx, req, false)
+}
+
+func (s *Service) calculate(ctx context.Context, req ExecuteRequest, store bool) (res Result, err error) {
+	ctx, span := tracing.Start(ctx, "service.calculate", tracing.Bool("store", store))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	t := s.tenant(ctx)
+	programs, annualRate, err := s.lookupProgram(ctx, t, req)
+	if err != nil {
+		return Result{}, err
 	}
-	if chosen > 1 {
-		return ExecuteResponse{}, 0, ErrChooseOnlyOneProgram
+
+	start := s.now()
+	clientID := auth.ClientID(ctx)
+	key := fingerprint(req, programs.Version, start, clientID)
+	_, lookupSpan := tracing.Start(ctx, "cache.lookup")
+	res, ok := t.lookup(key)
+	lookupSpan.SetAttributes(tracing.Bool("hit", ok))
+	lookupSpan.End()
+	if ok {
+		span.SetAttributes(tracing.Bool("cached", true), tracing.String("id", string(res.ID)))
+		calculations.Inc(res.Response.ProgramName(), "true")
+		slog.DebugContext(ctx, "calculation reused", "id", res.ID, "program", res.Response.ProgramName())
+		return res, nil
 	}
-	if req.InitialPayment < 0.2*req.ObjectCost {
-		return ExecuteResponse{}, 0, ErrInitialPaymentLow
+
+	_, calcSpan := tracing.Start(ctx, "calculate", tracing.Int("months", req.Months))
+	loanSum, payment, overpayment, lastDate, err := calculateCredit(req, annualRate, start)
+	calcSpan.RecordError(err)
+	calcSpan.End()
+	if err != nil {
+		return Result{}, err
 	}
-	loanSum, payment, overpayment, lastDate := calculateCredit(req, annualRate)
 	var resp ExecuteResponse
 	resp.Params.ObjectCost = req.ObjectCost
 	resp.Params.InitialPayment = req.InitialPayment
@@ -131,39 +269,117 @@ func (s *Service) Execute(req ExecuteRequest) (ExecuteResponse, int, error) {
 		Overpayment:     overpayment,
 		LastPaymentDate: lastDate,
 	}
+	resp.start = start
+
+	res = Result{Response: resp}
+	if store {
+		_, storeSpan := tracing.Start(ctx, "cache.store")
+		res = t.save(key, resp, clientID)
+		storeSpan.SetAttributes(tracing.String("id", string(res.ID)), tracing.Bool("cached", res.Cached))
+		storeSpan.End()
+	}
+	span.SetAttributes(tracing.Bool("cached", res.Cached), tracing.String("id", string(res.ID)))
+	calculations.Inc(resp.ProgramName(), strconv.FormatBool(res.Cached))
+	slog.DebugContext(ctx, "loan calculated", "id", res.ID, "program", resp.ProgramName(),
+		"stored", store, "loan_sum", loanSum, "monthly_payment", payment)
+	return res, nil
+}
+
+// lookupProgram loads the program catalogue of t and picks the rate of the
+// program chosen by req.
+func (s *Service) lookupProgram(ctx context.Context, t *tenantState, req ExecuteRequest) (Catalogue, float64, error) {
+	ctx, span := tracing.Start(ctx, "program.lookup")
+	defer span.End()
+
+	programs, err := s.catalogues.load(t.programsFile)
+	if err != nil {
+		span.RecordError(err)
+		catalogueLoads.Inc("failure")
+		slog.WarnContext(ctx, "program catalogue unavailable", "path", t.programsFile, "err", err)
+		return Catalogue{}, 0, err
+	}
+	catalogueLoads.Inc("success")
+	annualRate, err := chooseRate(req, programs.Rates)
+	if err != nil {
+		span.RecordError(err)
+		return Catalogue{}, 0, err
+	}
+	span.SetAttributes(tracing.String("program", ExecuteResponse{Program: req.Program}.ProgramName()), tracing.Float64("rate", annualRate),
+		tracing.String("catalogue_version", programs.Version))
+	return programs, annualRate, nil
+}
 
-	item := CacheItem{
-		ID:              len(s.cache.GetAll()),
-		ExecuteResponse: resp,
+func chooseRate(req ExecuteRequest, programRates map[string]float64) (float64, error) {
+	chosen := 0
+	var annualRate float64
+	for k, v := range req.Program {
+		if _, ok := validPrograms[k]; !ok {
+			return 0, &UnknownProgramError{Name: k}
+		}
+		if v {
+			chosen++
+			rate, ok := programRates[k]
+			if !ok {
+				return 0, fmt.Errorf("%w: no rate for program %s", ErrCatalogueUnavailable, k)
+			}
+			annualRate = rate
+		}
+	}
+	if req.InitialPayment > req.ObjectCost {
+		return 0, fmt.Errorf("%w: %f > %f", ErrFirstPaymentExceedsLoan, req.InitialPayment, req.ObjectCost)
+	}
+	if chosen == 0 {
+		return 0, ErrChooseProgram
+	}
+	if chosen > 1 {
+		return 0, ErrChooseOnlyOneProgram
+	}
+	if req.InitialPayment < 0.2*req.ObjectCost {
+		return 0, ErrInitialPaymentLow
 	}
-	id := s.cache.Add(item)
-	return resp, id, nil
+	return annualRate, nil
 }
 
-func calculateCredit(req ExecuteRequest, annualRate int) (loanSum, payment, overpayment float64, lastDate string) {
+// calculateCredit computes the annuity payment of the loan. A fully paid
+// object has nothing to repay and a zero rate is repaid in equal parts; the
+// annuity factor is computed as r/(1-(1+r)^-n), which stays finite for long
+// terms and tiny rates where (1+r)^n overflows or rounds to 1.
+func calculateCredit(req ExecuteRequest, annualRate float64, start time.Time) (loanSum, payment, overpayment float64, lastDate string, err error) {
+	if req.Months < 1 || req.Months > MaxMonths {
+		return 0, 0, 0, "", &ValidationError{Fields: []FieldError{{
+			Field:   "/months",
+			Message: fmt.Sprintf("must be an integer from 1 to %d", MaxMonths),
+		}}}
+	}
 	loanSum = req.ObjectCost - req.InitialPayment
-	r := float64(annualRate) / 12.0 / 100.0
+	r := annualRate / 12.0 / 100.0
 	n := float64(req.Months)
-	payment = loanSum * (r * math.Pow(1+r, n)) / (math.Pow(1+r, n) - 1)
+	switch {
+	case loanSum == 0:
+	case r == 0:
+		payment = loanSum / n
+	default:
+		payment = loanSum * r / -math.Expm1(-n*math.Log1p(r))
+	}
 
 	overpayment = (payment * n) - loanSum
 
-	payment = math.Round(payment*100) / 100.0
-	overpayment = math.Round(overpayment*100) / 100.0
+	payment = roundMoney(payment)
+	overpayment = roundMoney(overpayment)
+	if bad(loanSum) || bad(payment) || bad(overpayment) {
+		return 0, 0, 0, "", &ValidationError{Fields: []FieldError{{
+			Field:   "/object_cost",
+			Message: "is too large to calculate",
+		}}}
+	}
 
-	lastDate = time.Now().AddDate(0, req.Months, 0).Format("2006-01-02")
+	lastDate = addMonths(start, req.Months).Format(dateLayout)
 
-	return
+	return loanSum, payment, overpayment, lastDate, nil
 }
 
-// GetAll Cache Items.
-func (s *Service) GetAll() []CacheItem {
-	raw := s.cache.GetAll()
-	out := make([]CacheItem, 0, len(raw))
-	for _, x := range raw {
-		if ci, ok := x.(CacheItem); ok {
-			out = append(out, ci)
-		}
-	}
-	return out
+// GetAll Cache Items of the tenant ctx acts for.
+// The returned slice is a read-only snapshot shared with the cache.
+func (s *Service) GetAll(ctx context.Context) []CacheItem {
+	return s.tenant(ctx).store.GetAll()
 }
@@ -1,19 +1,34 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
 	"os"
-	"sber_test/internal/repo/cache"
+	"reflect"
 	"testing"
+	"testing/quick"
+	"time"
+
+	"sber_test/internal/auth"
+	"sber_test/internal/tenant"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestExecute(t *testing.T) {
+// TestMain runs the tests from the repository root, where programs.json is,
+// so that they don't depend on each other's working directory.
+func TestMain(m *testing.M) {
 	err := os.Chdir("../../")
 	if err != nil {
 		panic("failed to change directory: " + err.Error())
 	}
-	c := cache.New()
+	os.Exit(m.Run())
+}
+
+func TestExecute(t *testing.T) {
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -29,16 +44,16 @@ func TestExecute(t *testing.T) {
 
 	assert.Nil(t, err, "Expected no error")
 
-	assert.Equal(t, 0, id, "ID should be equal to 0")
+	assert.Equal(t, ID("0"), id, "ID should be equal to 0")
 
 	assert.Equal(t, req.InitialPayment, resp.Params.InitialPayment, "InitialPayment should match")
 	assert.Equal(t, req.Months, resp.Params.Months, "Months should match")
-	assert.Equal(t, 9, resp.Aggregates.Rate, "Rate should be 9 for military program")
+	assert.Equal(t, 9.0, resp.Aggregates.Rate, "Rate should be 9 for military program")
 	assert.Equal(t, 2000001.0, resp.Aggregates.LoanSum, "LoanSum should match")
 }
 
 func TestExecuteWithInvalidProgram(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -57,7 +72,7 @@ func TestExecuteWithInvalidProgram(t *testing.T) {
 }
 
 func TestExecuteWithMultiplePrograms(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -77,7 +92,7 @@ func TestExecuteWithMultiplePrograms(t *testing.T) {
 }
 
 func TestExecuteWithLowInitialPayment(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -96,7 +111,7 @@ func TestExecuteWithLowInitialPayment(t *testing.T) {
 }
 
 func TestExecuteWithEmptyProgram(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -113,15 +128,15 @@ func TestExecuteWithEmptyProgram(t *testing.T) {
 }
 
 func TestCacheWithEmptyItems(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
-	cacheItems := s.GetAll()
+	cacheItems := s.GetAll(context.Background())
 	assert.Empty(t, cacheItems, "Cache should be empty initially")
 }
 
 func TestExecuteWithZeroObjectCost(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -138,11 +153,11 @@ func TestExecuteWithZeroObjectCost(t *testing.T) {
 	assert.Nil(t, err, "Expected no error")
 	assert.Equal(t, 0.0, resp.Aggregates.LoanSum, "Loan sum should be 0 when ObjectCost is 0")
 	assert.Equal(t, 0.0, resp.Aggregates.MonthlyPayment, "Monthly payment should be 0 when ObjectCost is 0")
-	assert.Equal(t, 0, id, "ID should be equal to 0")
+	assert.Equal(t, ID("0"), id, "ID should be equal to 0")
 }
 
 func TestExecuteWithLargeObjectCost(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -159,11 +174,11 @@ func TestExecuteWithLargeObjectCost(t *testing.T) {
 	assert.Nil(t, err, "Expected no error")
 	assert.Greater(t, resp.Aggregates.LoanSum, 0.0, "Loan sum should be positive")
 	assert.Greater(t, resp.Aggregates.MonthlyPayment, 0.0, "Monthly payment should be positive")
-	assert.Equal(t, 0, id, "ID should be equal to 0")
+	assert.Equal(t, ID("0"), id, "ID should be equal to 0")
 }
 
 func TestExecuteWithNoProgram(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -180,7 +195,7 @@ func TestExecuteWithNoProgram(t *testing.T) {
 }
 
 func TestExecuteWithLongTerm(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -195,13 +210,13 @@ func TestExecuteWithLongTerm(t *testing.T) {
 	resp, id, err := s.Execute(req)
 
 	assert.Nil(t, err, "Expected no error")
-	assert.Equal(t, 0, id, "ID should be equal to 0")
-	assert.Equal(t, 9, resp.Aggregates.Rate, "Rate should be 9 for military program")
+	assert.Equal(t, ID("0"), id, "ID should be equal to 0")
+	assert.Equal(t, 9.0, resp.Aggregates.Rate, "Rate should be 9 for military program")
 	assert.Equal(t, 2000001.0, resp.Aggregates.LoanSum, "LoanSum should match")
 }
 
 func TestCacheWithAddedItems(t *testing.T) {
-	c := cache.New()
+	c := NewStore(SequentialIDs(), 0)
 	s := New(c)
 
 	req := ExecuteRequest{
@@ -215,9 +230,494 @@ func TestCacheWithAddedItems(t *testing.T) {
 
 	_, id, err := s.Execute(req)
 	assert.Nil(t, err)
-	assert.Equal(t, 0, id, "ID should be equal to 0")
+	assert.Equal(t, ID("0"), id, "ID should be equal to 0")
 
-	cacheItems := s.GetAll()
+	cacheItems := s.GetAll(context.Background())
 	assert.NotEmpty(t, cacheItems, "Cache should not be empty")
 	assert.Equal(t, id, cacheItems[0].ID, "ID should match the inserted item")
 }
+
+func TestExecuteMemoisesIdenticalRequests(t *testing.T) {
+	c := NewStore(SequentialIDs(), 0)
+	s := New(c)
+
+	req := ExecuteRequest{
+		ObjectCost:     5000000,
+		InitialPayment: 1000000,
+		Months:         240,
+		Program: map[string]bool{
+			"salary": true,
+		},
+	}
+
+	first, err := s.Calculate(context.Background(), req)
+	assert.Nil(t, err)
+	assert.False(t, first.Cached, "First calculation should not be a cache hit")
+
+	second, err := s.Calculate(context.Background(), req)
+	assert.Nil(t, err)
+	assert.True(t, second.Cached, "Identical request should be a cache hit")
+	assert.Equal(t, first.ID, second.ID, "Cache hit should return the original ID")
+	assert.Equal(t, first.Response, second.Response, "Cache hit should return the original result")
+	assert.Len(t, s.GetAll(context.Background()), 1, "Cache hit should not add an item")
+
+	req.Months = 120
+	third, err := s.Calculate(context.Background(), req)
+	assert.Nil(t, err)
+	assert.False(t, third.Cached, "Different request should not be a cache hit")
+	assert.Len(t, s.GetAll(context.Background()), 2)
+}
+
+func TestCalculateRecordsClient(t *testing.T) {
+	s := New(NewStore(SequentialIDs(), 0))
+	req := ExecuteRequest{ObjectCost: 5000000, InitialPayment: 1000000, Months: 240, Program: map[string]bool{"base": true}}
+	bankA := auth.WithPrincipal(context.Background(), auth.Principal{ClientID: "bank-a"})
+	bankB := auth.WithPrincipal(context.Background(), auth.Principal{ClientID: "bank-b"})
+
+	a, err := s.Calculate(bankA, req)
+	assert.NoError(t, err)
+	b, err := s.Calculate(bankB, req)
+	assert.NoError(t, err)
+	assert.False(t, b.Cached, "Clients should not reuse each other's calculations")
+	again, err := s.Calculate(bankA, req)
+	assert.NoError(t, err)
+	assert.True(t, again.Cached)
+	assert.Equal(t, a.ID, again.ID)
+
+	items := s.GetAll(context.Background())
+	assert.Len(t, items, 2)
+	assert.Equal(t, "bank-a", items[0].ClientID)
+	assert.Equal(t, "bank-b", items[1].ClientID)
+}
+
+func TestFingerprint(t *testing.T) {
+	start := time.Date(2024, 2, 18, 10, 0, 0, 0, time.UTC)
+	req := ExecuteRequest{
+		ObjectCost:     5000000,
+		InitialPayment: 1000000,
+		Months:         240,
+		Program:        map[string]bool{"salary": true},
+	}
+	withOff := req
+	withOff.Program = map[string]bool{"salary": true, "base": false}
+
+	base := fingerprint(req, "1", start, "")
+	assert.Equal(t, base, fingerprint(withOff, "1", start, ""), "Disabled programs should not change the key")
+	assert.Equal(t, base, fingerprint(req, "1", start.Add(time.Hour), ""), "Time of day should not change the key")
+	assert.NotEqual(t, base, fingerprint(req, "2", start, ""), "Catalogue version should change the key")
+	assert.NotEqual(t, base, fingerprint(req, "1", start.AddDate(0, 0, 1), ""), "Start date should change the key")
+	assert.NotEqual(t, base, fingerprint(req, "1", start, "bank-a"), "Client should change the key")
+}
+
+func TestSequentialIDs(t *testing.T) {
+	next := SequentialIDs()
+	assert.Equal(t, ID("0"), next())
+	assert.Equal(t, ID("1"), next())
+
+	data, err := json.Marshal(CacheItem{ID: next()})
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"id":2`, "Sequential IDs should be encoded as numbers")
+}
+
+func TestTimeOrderedIDs(t *testing.T) {
+	next := TimeOrderedIDs()
+	prev := next()
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, string(prev))
+	for i := 0; i < 5000; i++ {
+		id := next()
+		assert.Less(t, string(prev), string(id), "UUIDv7 IDs should sort in creation order")
+		prev = id
+	}
+
+	data, err := json.Marshal(CacheItem{ID: prev})
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"id":"`+string(prev)+`"`, "Opaque IDs should be encoded as strings")
+
+	var item CacheItem
+	assert.Nil(t, json.Unmarshal(data, &item))
+	assert.Equal(t, prev, item.ID)
+}
+
+func TestExecuteWithTimeOrderedIDs(t *testing.T) {
+	s := New(NewStore(TimeOrderedIDs(), 0))
+
+	req := ExecuteRequest{
+		ObjectCost:     5000000,
+		InitialPayment: 1000000,
+		Months:         240,
+		Program:        map[string]bool{"base": true},
+	}
+	_, id, err := s.Execute(req)
+	assert.Nil(t, err)
+	assert.Equal(t, id, s.GetAll(context.Background())[0].ID, "Stored ID should match the returned one")
+}
+
+func TestSchedule(t *testing.T) {
+	s := New(NewStore(SequentialIDs(), 0))
+
+	resp, _, err := s.Execute(ExecuteRequest{
+		ObjectCost:     5000000,
+		InitialPayment: 1000000,
+		Months:         240,
+		Program:        map[string]bool{"salary": true},
+	})
+	assert.Nil(t, err)
+
+	schedule, err := Schedule(resp)
+	assert.Nil(t, err)
+	assert.Len(t, schedule, 240)
+
+	var principal float64
+	for _, inst := range schedule {
+		principal += inst.Principal
+	}
+	assert.InDelta(t, resp.Aggregates.LoanSum, principal, 0.005, "Principals should add up to the loan sum")
+	assert.Equal(t, 0.0, schedule[len(schedule)-1].Balance, "Loan should be repaid")
+	assert.Equal(t, resp.Aggregates.LastPaymentDate, schedule[len(schedule)-1].Date)
+	assert.Equal(t, resp.Aggregates.MonthlyPayment, schedule[0].Payment)
+	assert.Equal(t, "salary", resp.ProgramName())
+}
+
+func TestScheduleMonthEnds(t *testing.T) {
+	path := t.TempDir() + "/rates.json"
+	assert.NoError(t, os.WriteFile(path, []byte(`{"program_rates":{"base":10}}`), 0o600))
+	s := New(NewStore(SequentialIDs(), 0), WithProgramsFile(path))
+	s.now = func() time.Time { return time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC) }
+	resp, _, err := s.Execute(ExecuteRequest{
+		ObjectCost:     1200000,
+		InitialPayment: 240000,
+		Months:         12,
+		Program:        map[string]bool{"base": true},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "2025-03-31", resp.Aggregates.LastPaymentDate)
+
+	schedule, err := Schedule(resp)
+	assert.NoError(t, err)
+	dates := make([]string, len(schedule))
+	for i, inst := range schedule {
+		dates[i] = inst.Date
+	}
+	assert.Equal(t, []string{
+		"2024-04-30", "2024-05-31", "2024-06-30", "2024-07-31", "2024-08-31", "2024-09-30",
+		"2024-10-31", "2024-11-30", "2024-12-31", "2025-01-31", "2025-02-28", "2025-03-31",
+	}, dates, "Installments should fall on the 31st or the last day of shorter months")
+
+	s.now = func() time.Time { return time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC) }
+	resp, _, err = s.Execute(ExecuteRequest{
+		ObjectCost:     1200000,
+		InitialPayment: 240000,
+		Months:         1,
+		Program:        map[string]bool{"base": true},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-02-29", resp.Aggregates.LastPaymentDate, "The last payment shouldn't overflow into March")
+}
+
+func TestPreviewDoesNotStore(t *testing.T) {
+	s := New(NewStore(SequentialIDs(), 0))
+
+	req := ExecuteRequest{
+		ObjectCost:     5000000,
+		InitialPayment: 1000000,
+		Months:         240,
+		Program:        map[string]bool{"salary": true},
+	}
+	res, err := s.Preview(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, 8.0, res.Response.Aggregates.Rate)
+	assert.Equal(t, ID(""), res.ID, "Preview should not allocate an ID")
+	assert.Empty(t, s.GetAll(context.Background()), "Preview should not write to the cache")
+
+	stored, err := s.Calculate(context.Background(), req)
+	assert.Nil(t, err)
+	res, err = s.Preview(context.Background(), req)
+	assert.Nil(t, err)
+	assert.True(t, res.Cached, "Preview should reuse a cached calculation")
+	assert.Equal(t, stored.ID, res.ID)
+}
+
+func TestDecodeRequest(t *testing.T) {
+	req, err := DecodeRequest([]byte(`{"object_cost":5000000,"initial_payment":1000000,"months":240,"program":{"salary":true}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ExecuteRequest{ObjectCost: 5000000, InitialPayment: 1000000, Months: 240, Program: map[string]bool{"salary": true}}, req)
+
+	_, err = DecodeRequest([]byte(`{"object_cost":-5,"months":0,"program":{"salary":"yes","a/b":true},"extra":1}`))
+	var verr *ValidationError
+	if assert.ErrorAs(t, err, &verr) {
+		var fields []string
+		for _, f := range verr.Fields {
+			fields = append(fields, f.Field)
+		}
+		assert.Equal(t, []string{"/extra", "/initial_payment", "/months", "/object_cost", "/program/a~1b", "/program/salary"}, fields)
+	}
+	assert.ErrorIs(t, err, ErrValidation)
+
+	_, err = DecodeRequest([]byte(`{"months":240.5,"object_cost":1,"initial_payment":0,"program":{"base":true}}`))
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, []FieldError{{Field: "/months", Message: "must be an integer"}}, verr.Fields)
+
+	for _, body := range []string{`{"object_cost":`, `[]`, `null`, `{} {}`} {
+		_, err = DecodeRequest([]byte(body))
+		assert.ErrorIs(t, err, ErrMalformedRequest, body)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	req := ExecuteRequest{ObjectCost: 2 * MaxObjectCost, InitialPayment: 0, Months: MaxMonths + 1, Program: map[string]bool{"gold": true}}
+	var verr *ValidationError
+	if assert.ErrorAs(t, req.Validate(), &verr) {
+		assert.Len(t, verr.Fields, 3)
+	}
+	req = ExecuteRequest{ObjectCost: 100, InitialPayment: 20, Months: 12, Program: map[string]bool{"base": true}}
+	assert.NoError(t, req.Validate())
+}
+
+// loanCase is a random valid loan for property tests.
+type loanCase struct {
+	Req  ExecuteRequest
+	Rate float64
+}
+
+func (loanCase) Generate(r *rand.Rand, _ int) reflect.Value {
+	cost := math.Ceil(r.Float64() * MaxObjectCost)
+	c := loanCase{
+		Req: ExecuteRequest{
+			ObjectCost: cost,
+			Months:     1 + r.Intn(MaxMonths),
+		},
+		Rate: float64(r.Intn(3001)) / 100,
+	}
+	switch r.Intn(4) {
+	case 0:
+		c.Req.InitialPayment = cost
+	case 1:
+		c.Rate = 0
+		fallthrough
+	default:
+		c.Req.InitialPayment = math.Round(cost * (0.2 + 0.8*r.Float64()))
+	}
+	return reflect.ValueOf(c)
+}
+
+func TestCalculateCreditIsFinite(t *testing.T) {
+	start := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	property := func(c loanCase) bool {
+		loanSum, payment, overpayment, _, err := calculateCredit(c.Req, c.Rate, start)
+		return err == nil && !bad(loanSum) && !bad(payment) && !bad(overpayment) &&
+			payment >= 0 && overpayment >= -0.01*float64(c.Req.Months)
+	}
+	assert.NoError(t, quick.Check(property, nil))
+}
+
+func TestSchedulePrincipalSumsToLoan(t *testing.T) {
+	start := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	property := func(c loanCase) bool {
+		loanSum, payment, overpayment, lastDate, err := calculateCredit(c.Req, c.Rate, start)
+		if err != nil {
+			return false
+		}
+		var resp ExecuteResponse
+		resp.Params.Months = c.Req.Months
+		resp.Aggregates = Aggregates{
+			Rate:            c.Rate,
+			LoanSum:         loanSum,
+			MonthlyPayment:  payment,
+			Overpayment:     overpayment,
+			LastPaymentDate: lastDate,
+		}
+		schedule, err := Schedule(resp)
+		if err != nil || len(schedule) != c.Req.Months {
+			return false
+		}
+		// Sum in kopecks: adding floats of this size loses cents.
+		var sum int64
+		for _, inst := range schedule {
+			if bad(inst.Payment) || bad(inst.Interest) || bad(inst.Balance) {
+				return false
+			}
+			sum += int64(math.Round(inst.Principal * 100))
+		}
+		return sum == int64(math.Round(loanSum*100)) && schedule[len(schedule)-1].Balance == 0
+	}
+	assert.NoError(t, quick.Check(property, &quick.Config{MaxCount: 200}))
+}
+
+func TestCalculateCreditEdgeCases(t *testing.T) {
+	start := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	req := ExecuteRequest{ObjectCost: 1200, InitialPayment: 240, Months: 12}
+
+	loanSum, payment, overpayment, _, err := calculateCredit(req, 0, start)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{960, 80, 0}, []float64{loanSum, payment, overpayment}, "zero rate is repaid in equal parts")
+
+	req.InitialPayment = req.ObjectCost
+	_, payment, overpayment, _, err = calculateCredit(req, 9, start)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{0, 0}, []float64{payment, overpayment}, "fully paid object")
+
+	req.InitialPayment = 240
+	for _, months := range []int{0, -1, MaxMonths + 1} {
+		req.Months = months
+		_, _, _, _, err = calculateCredit(req, 9, start)
+		assert.ErrorIs(t, err, ErrValidation, "months %d", months)
+	}
+
+	req = ExecuteRequest{ObjectCost: math.MaxFloat64, Months: MaxMonths}
+	_, _, _, _, err = calculateCredit(req, 30, start)
+	assert.ErrorIs(t, err, ErrValidation, "overflowing loan")
+}
+
+func TestWithProgramsFile(t *testing.T) {
+	req := ExecuteRequest{ObjectCost: 5000000, InitialPayment: 1000000, Months: 240, Program: map[string]bool{"base": true}}
+
+	s := New(NewStore(SequentialIDs(), 0), WithProgramsFile("missing.json"))
+	_, err := s.Calculate(context.Background(), req)
+	assert.ErrorIs(t, err, ErrCatalogueUnavailable)
+	assert.ErrorContains(t, err, "missing.json")
+
+	path := t.TempDir() + "/rates.json"
+	assert.NoError(t, os.WriteFile(path, []byte(`{"program_rates":{"base":5}}`), 0o600))
+	s = New(NewStore(SequentialIDs(), 0), WithProgramsFile(path))
+	res, err := s.Calculate(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, res.Response.Aggregates.Rate)
+
+	// Rates may be fractional, in JSON and in YAML catalogues alike.
+	for name, data := range map[string]string{
+		"rates.json": `{"program_rates":{"base":7.5}}`,
+		"rates.yaml": "program_rates:\n  base: 7.5\n",
+	} {
+		path = t.TempDir() + "/" + name
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+		s = New(NewStore(SequentialIDs(), 0), WithProgramsFile(path))
+		res, err = s.Calculate(context.Background(), req)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 7.5, res.Response.Aggregates.Rate, name)
+		assert.Equal(t, 32223.73, res.Response.Aggregates.MonthlyPayment, name)
+	}
+
+	for _, data := range []string{`{"program_rates":{"base":-1}}`, "program_rates:\n  base: .nan\n"} {
+		path = t.TempDir() + "/rates.yml"
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+		_, err = New(NewStore(SequentialIDs(), 0), WithProgramsFile(path)).Calculate(context.Background(), req)
+		assert.ErrorIs(t, err, ErrCatalogueUnavailable, data)
+		assert.ErrorContains(t, err, "invalid rate for program base", data)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	path := t.TempDir() + "/rates.json"
+	assert.NoError(t, os.WriteFile(path, []byte(`{"version":"bank-b-1","program_rates":{"base":5}}`), 0o600))
+	s := New(NewStore(SequentialIDs(), 0),
+		WithTenant("bank-a", TenantConfig{RetainItems: 1}),
+		WithTenant("bank-b", TenantConfig{ProgramsFile: path}))
+	req := ExecuteRequest{ObjectCost: 5000000, InitialPayment: 1000000, Months: 240, Program: map[string]bool{"base": true}}
+	bankA := tenant.With(context.Background(), "bank-a")
+	bankB := tenant.With(context.Background(), "bank-b")
+
+	def, err := s.Calculate(context.Background(), req)
+	assert.NoError(t, err)
+	a, err := s.Calculate(bankA, req)
+	assert.NoError(t, err)
+	assert.False(t, a.Cached, "Tenants should not reuse each other's calculations")
+	assert.NotEqual(t, def.ID, a.ID, "IDs stay unique across tenants")
+	b, err := s.Calculate(bankB, req)
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, b.Response.Aggregates.Rate, "bank-b has its own catalogue")
+	assert.NotEqual(t, 5.0, a.Response.Aggregates.Rate)
+
+	req.Months = 120
+	_, err = s.Calculate(bankA, req)
+	assert.NoError(t, err)
+	items := s.GetAll(bankA)
+	assert.Len(t, items, 1, "bank-a keeps at most one calculation")
+	assert.Equal(t, 120, items[0].Params.Months)
+	assert.Equal(t, []CacheItem{{ExecuteResponse: def.Response, ID: def.ID}}, s.GetAll(context.Background()))
+	assert.Len(t, s.GetAll(bankB), 1)
+	assert.Len(t, s.GetAll(tenant.With(context.Background(), "bank-c")), 0)
+	assert.Equal(t, 3, s.Len())
+	assert.Equal(t, uint64(1), s.Evictions())
+
+	version, err := s.CatalogueVersion(bankB)
+	assert.NoError(t, err)
+	assert.Equal(t, "bank-b-1", version)
+	assert.NoError(t, s.CheckCatalogues())
+	assert.NoError(t, s.CheckCaches(bankB))
+	s = New(NewStore(SequentialIDs(), 0), WithTenant("bank-b", TenantConfig{ProgramsFile: "missing.json"}))
+	assert.ErrorContains(t, s.CheckCatalogues(), "missing.json")
+}
+
+func TestTenantLimits(t *testing.T) {
+	now := time.Date(2024, 2, 18, 10, 0, 0, 0, time.UTC)
+	s := New(NewStore(SequentialIDs(), 0), WithTenantLimits(2, time.Hour))
+	s.now = func() time.Time { return now }
+	req := ExecuteRequest{ObjectCost: 5000000, InitialPayment: 1000000, Months: 240, Program: map[string]bool{"base": true}}
+	calculate := func(id string) {
+		t.Helper()
+		now = now.Add(time.Minute)
+		_, err := s.Calculate(tenant.With(context.Background(), id), req)
+		assert.NoError(t, err)
+	}
+
+	calculate(tenant.Default)
+	calculate("bank-a")
+	calculate("bank-b")
+	calculate("bank-a")
+	calculate("bank-c")
+	assert.Equal(t, 3, s.Tenants(), "the default tenant doesn't count against the limit")
+	assert.Equal(t, uint64(1), s.DroppedTenants())
+	assert.Len(t, s.GetAll(tenant.With(context.Background(), "bank-a")), 1, "the recently active tenant is kept")
+	assert.Len(t, s.GetAll(tenant.With(context.Background(), "bank-b")), 0, "the least recently active tenant is dropped")
+
+	now = now.Add(time.Hour)
+	calculate("bank-d")
+	assert.Equal(t, 2, s.Tenants(), "idle tenants are dropped")
+	assert.Len(t, s.GetAll(context.Background()), 1, "the default tenant is never dropped")
+}
+
+func TestCataloguesReloadOnChange(t *testing.T) {
+	path := t.TempDir() + "/rates.json"
+	assert.NoError(t, os.WriteFile(path, []byte(`{"version":"1","program_rates":{"base":5}}`), 0o600))
+	c := newCatalogues()
+	programs, err := c.load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", programs.Version)
+
+	// Same size and modification time: the parsed catalogue is reused.
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, []byte(`{"version":"2","program_rates":{"base":5}}`), 0o600))
+	assert.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	programs, err = c.load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", programs.Version)
+
+	assert.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime().Add(time.Second)))
+	programs, err = c.load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", programs.Version)
+
+	assert.NoError(t, os.Remove(path))
+	_, err = c.load(path)
+	assert.ErrorIs(t, err, ErrCatalogueUnavailable)
+}
+
+func TestBoundedStoreEvictsOldest(t *testing.T) {
+	s := New(NewStore(SequentialIDs(), 2))
+	req := ExecuteRequest{ObjectCost: 5000000, InitialPayment: 1000000, Months: 240, Program: map[string]bool{"base": true}}
+	for months := 240; months < 243; months++ {
+		req.Months = months
+		_, err := s.Calculate(context.Background(), req)
+		assert.NoError(t, err)
+	}
+	items := s.GetAll(context.Background())
+	assert.Len(t, items, 2)
+	assert.Equal(t, ID("1"), items[0].ID)
+
+	req.Months = 240
+	res, err := s.Calculate(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, res.Cached, "an evicted calculation is recalculated")
+	assert.Equal(t, ID("3"), res.ID)
+}
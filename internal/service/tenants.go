@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sber_test/internal/repo/cache"
+	"sber_test/internal/tenant"
+)
+
+// TenantConfig overrides the defaults of the service for one tenant.
+type TenantConfig struct {
+	// RetainItems is how many calculations the tenant keeps, evicting its
+	// oldest ones first. It is a retention size, not a quota: new
+	// calculations are never refused. Zero means the limit of the store
+	// passed to New.
+	RetainItems int
+	// ProgramsFile is the program catalogue of the tenant. Empty means the
+	// catalogue of the service.
+	ProgramsFile string
+}
+
+// WithTenant overrides the defaults for the tenant id. The default tenant
+// always keeps the limit of the store passed to New.
+func WithTenant(id string, cfg TenantConfig) Option {
+	return func(s *Service) {
+		s.configs[id] = cfg
+	}
+}
+
+// WithTenantLimits bounds the tenants whose calculations are kept, besides
+// the default tenant, to n, dropping the least recently active one to
+// make room, and drops tenants inactive for idle. A dropped tenant starts
+// over with no calculations when it comes back. Zero means no limit.
+func WithTenantLimits(n int, idle time.Duration) Option {
+	return func(s *Service) {
+		s.maxTenants, s.tenantIdle = n, idle
+	}
+}
+
+// tenantState is the cache of one tenant. Tenants never see each other's
+// calculations.
+type tenantState struct {
+	// mu makes looking up and storing a calculation atomic.
+	mu           sync.Mutex
+	store        *Store
+	memo         *cache.Cache[string, ID]
+	programsFile string
+	// lastUsed is when the tenant last acted, in Unix nanoseconds.
+	lastUsed atomic.Int64
+}
+
+func newTenantState(store *Store, programsFile string) *tenantState {
+	return &tenantState{
+		store: store,
+		// The memo gets at most one entry per stored calculation, so the
+		// store's limit bounds it as well.
+		memo:         cache.New[string, ID](cache.WithMaxItems[string, ID](store.MaxItems())),
+		programsFile: programsFile,
+	}
+}
+
+// tenant returns the state of the tenant ctx acts for, creating it on first
+// use. The stores of all tenants share the ID generator of the store passed
+// to New, so IDs stay unique across tenants.
+func (s *Service) tenant(ctx context.Context) *tenantState {
+	id := tenant.FromContext(ctx)
+	now := s.now()
+	s.tenantsMu.RLock()
+	t, ok := s.tenants[id]
+	s.tenantsMu.RUnlock()
+	if ok {
+		t.lastUsed.Store(now.UnixNano())
+		return t
+	}
+
+	s.tenantsMu.Lock()
+	defer s.tenantsMu.Unlock()
+	if t, ok := s.tenants[id]; ok {
+		t.lastUsed.Store(now.UnixNano())
+		return t
+	}
+	s.dropTenantsLocked(now)
+	cfg := s.configs[id]
+	if cfg.RetainItems == 0 {
+		cfg.RetainItems = s.maxItems
+	}
+	if cfg.ProgramsFile == "" {
+		cfg.ProgramsFile = s.programsFile
+	}
+	t = newTenantState(NewStore(s.ids, cfg.RetainItems), cfg.ProgramsFile)
+	t.lastUsed.Store(now.UnixNano())
+	s.tenants[id] = t
+	return t
+}
+
+// dropTenantsLocked drops the tenants idle for longer than the limit and,
+// if that leaves no room for one more, the least recently active one. The
+// default tenant is never dropped.
+func (s *Service) dropTenantsLocked(now time.Time) {
+	var oldest string
+	var oldestUsed int64
+	for id, t := range s.tenants {
+		if id == tenant.Default {
+			continue
+		}
+		used := t.lastUsed.Load()
+		if s.tenantIdle > 0 && now.Sub(time.Unix(0, used)) >= s.tenantIdle {
+			s.dropTenantLocked(id, t)
+			continue
+		}
+		if oldest == "" || used < oldestUsed {
+			oldest, oldestUsed = id, used
+		}
+	}
+	// The default tenant doesn't count against the limit.
+	if s.maxTenants > 0 && len(s.tenants)-1 >= s.maxTenants && oldest != "" {
+		s.dropTenantLocked(oldest, s.tenants[oldest])
+	}
+}
+
+func (s *Service) dropTenantLocked(id string, t *tenantState) {
+	delete(s.tenants, id)
+	s.droppedTenants++
+	s.droppedEvictions += t.store.Evictions()
+}
+
+// states returns the state of every tenant kept.
+func (s *Service) states() []*tenantState {
+	s.tenantsMu.RLock()
+	defer s.tenantsMu.RUnlock()
+
+	states := make([]*tenantState, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		states = append(states, t)
+	}
+	return states
+}
+
+// Len returns the number of calculations kept for all tenants.
+func (s *Service) Len() int {
+	n := 0
+	for _, t := range s.states() {
+		n += t.store.Len()
+	}
+	return n
+}
+
+// Evictions returns the number of calculations evicted to keep tenants
+// within their limits.
+func (s *Service) Evictions() uint64 {
+	s.tenantsMu.RLock()
+	n := s.droppedEvictions
+	s.tenantsMu.RUnlock()
+	for _, t := range s.states() {
+		n += t.store.Evictions()
+	}
+	return n
+}
+
+// Tenants returns the number of tenants whose calculations are kept.
+func (s *Service) Tenants() int {
+	s.tenantsMu.RLock()
+	defer s.tenantsMu.RUnlock()
+
+	return len(s.tenants)
+}
+
+// DroppedTenants returns the number of tenants dropped by the limits set
+// with WithTenantLimits.
+func (s *Service) DroppedTenants() uint64 {
+	s.tenantsMu.RLock()
+	defer s.tenantsMu.RUnlock()
+
+	return s.droppedTenants
+}
+
+// CheckCatalogues reports an error if the catalogue of the service or of
+// any configured tenant can't be loaded.
+func (s *Service) CheckCatalogues() error {
+	paths := map[string]bool{s.programsFile: true}
+	for _, cfg := range s.configs {
+		if cfg.ProgramsFile != "" {
+			paths[cfg.ProgramsFile] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	var errs []error
+	for _, path := range sorted {
+		if _, err := s.catalogues.load(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// probeID is the key CheckCaches writes; no ID generator produces it.
+const probeID ID = "readyz-probe"
+
+// CheckCaches reports an error if the cache of any tenant can't take a
+// write before ctx is done.
+func (s *Service) CheckCaches(ctx context.Context) error {
+	s.tenantsMu.RLock()
+	ids := make([]string, 0, len(s.tenants))
+	states := make(map[string]*tenantState, len(s.tenants))
+	for id, t := range s.tenants {
+		ids = append(ids, id)
+		states[id] = t
+	}
+	s.tenantsMu.RUnlock()
+
+	sort.Strings(ids)
+	var errs []error
+	for _, id := range ids {
+		if err := states[id].store.Probe(ctx, probeID); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Limits enforced by Validate.
+const (
+	MaxObjectCost = 1e13
+	MaxMonths     = 600
+)
+
+// validPrograms are the program names a request may mention.
+var validPrograms = map[string]struct{}{
+	"salary":   {},
+	"military": {},
+	"base":     {},
+}
+
+// FieldError describes one invalid request field.
+type FieldError struct {
+	// Field is a JSON pointer (RFC 6901) to the field, e.g. "/program/salary".
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found in a request.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return ErrValidation.Error() + ": " + strings.Join(parts, "; ")
+}
+
+// Unwrap makes errors.Is(err, ErrValidation) hold.
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// add records a problem with field unless one was already recorded, so a
+// field that failed to decode isn't also reported as out of range.
+func (e *ValidationError) add(field, format string, args ...any) {
+	for _, f := range e.Fields {
+		if f.Field == field {
+			return
+		}
+	}
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	sort.SliceStable(e.Fields, func(i, j int) bool { return e.Fields[i].Field < e.Fields[j].Field })
+	return e
+}
+
+// DecodeRequest strictly decodes a JSON execute request and validates it.
+// Unknown fields, wrong types and out-of-range values are all collected
+// into one *ValidationError; malformed JSON yields ErrMalformedRequest.
+func DecodeRequest(data []byte) (ExecuteRequest, error) {
+	var raw map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&raw); err != nil {
+		return ExecuteRequest{}, fmt.Errorf("%w: %w", ErrMalformedRequest, err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return ExecuteRequest{}, fmt.Errorf("%w: unexpected data after the request object", ErrMalformedRequest)
+	}
+	if raw == nil {
+		return ExecuteRequest{}, fmt.Errorf("%w: request must be a JSON object", ErrMalformedRequest)
+	}
+
+	var (
+		req  ExecuteRequest
+		verr ValidationError
+	)
+	for _, name := range []string{"object_cost", "initial_payment", "months", "program"} {
+		if _, ok := raw[name]; !ok {
+			verr.add("/"+name, "is required")
+		}
+	}
+	for name, value := range raw {
+		field := FieldPointer(name)
+		switch name {
+		case "object_cost":
+			req.ObjectCost = decodeNumber(&verr, field, value)
+		case "initial_payment":
+			req.InitialPayment = decodeNumber(&verr, field, value)
+		case "months":
+			req.Months = decodeInteger(&verr, field, value)
+		case "program":
+			req.Program = decodeProgram(&verr, field, value)
+		default:
+			verr.add(field, "unknown field")
+		}
+	}
+	req.validate(&verr)
+	return req, verr.err()
+}
+
+// Validate checks that req is within the supported ranges and mentions only
+// known programs. All violations are reported together in a *ValidationError.
+func (r ExecuteRequest) Validate() error {
+	var verr ValidationError
+	r.validate(&verr)
+	for name := range r.Program {
+		if _, ok := validPrograms[name]; !ok {
+			verr.add(FieldPointer("program", name), "unknown program")
+		}
+	}
+	return verr.err()
+}
+
+func (r ExecuteRequest) validate(verr *ValidationError) {
+	if bad(r.ObjectCost) || r.ObjectCost <= 0 || r.ObjectCost > MaxObjectCost {
+		verr.add("/object_cost", "must be greater than 0 and at most %g", MaxObjectCost)
+	}
+	if bad(r.InitialPayment) || r.InitialPayment < 0 || r.InitialPayment > MaxObjectCost {
+		verr.add("/initial_payment", "must be at least 0 and at most %g", MaxObjectCost)
+	}
+	if r.Months < 1 || r.Months > MaxMonths {
+		verr.add("/months", "must be an integer from 1 to %d", MaxMonths)
+	}
+}
+
+func bad(f float64) bool {
+	return math.IsNaN(f) || math.IsInf(f, 0)
+}
+
+func decodeNumber(verr *ValidationError, field string, value json.RawMessage) float64 {
+	var f float64
+	if err := json.Unmarshal(value, &f); err != nil {
+		verr.add(field, "must be a finite number")
+		return 0
+	}
+	return f
+}
+
+func decodeInteger(verr *ValidationError, field string, value json.RawMessage) int {
+	var f float64
+	if err := json.Unmarshal(value, &f); err != nil || f != math.Trunc(f) || math.Abs(f) > math.MaxInt32 {
+		verr.add(field, "must be an integer")
+		return 0
+	}
+	return int(f)
+}
+
+func decodeProgram(verr *ValidationError, field string, value json.RawMessage) map[string]bool {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(value, &raw); err != nil || raw == nil {
+		verr.add(field, "must be an object")
+		return nil
+	}
+	program := make(map[string]bool, len(raw))
+	for name, v := range raw {
+		sub := FieldPointer("program", name)
+		if _, ok := validPrograms[name]; !ok {
+			verr.add(sub, "unknown program")
+			continue
+		}
+		var on bool
+		if err := json.Unmarshal(v, &on); err != nil {
+			verr.add(sub, "must be a boolean")
+			continue
+		}
+		program[name] = on
+	}
+	return program
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// FieldPointer returns the JSON pointer (RFC 6901) to the field at path.
+func FieldPointer(path ...string) string {
+	var b strings.Builder
+	for _, token := range path {
+		b.WriteByte('/')
+		b.WriteString(pointerEscaper.Replace(token))
+	}
+	return b.String()
+}
@@ -0,0 +1,44 @@
+// Package tenant carries the tenant a request acts for through contexts.
+// Every tenant sees only its own calculations.
+package tenant
+
+import "context"
+
+// Header names the tenant of a request when it comes from a trusted proxy.
+const Header = "X-Tenant-ID"
+
+// Default is the tenant of requests that name none, e.g. anonymous ones
+// when authentication is disabled.
+const Default = ""
+
+// maxLen bounds the length of a tenant ID.
+const maxLen = 64
+
+// Valid reports whether id may name a tenant: 1 to 64 letters, digits and
+// the characters "-", "_" and ".".
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type key struct{}
+
+// With returns a copy of ctx acting for tenant id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// FromContext returns the tenant ctx acts for, or Default.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
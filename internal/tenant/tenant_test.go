@@ -0,0 +1,29 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	for _, id := range []string{"bank-a", "Bank_B.1", strings.Repeat("x", 64)} {
+		if !Valid(id) {
+			t.Errorf("expected %q valid", id)
+		}
+	}
+	for _, id := range []string{"", "bank a", "bank/a", "банк", strings.Repeat("x", 65)} {
+		if Valid(id) {
+			t.Errorf("expected %q invalid", id)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != Default {
+		t.Errorf("expected the default tenant, got %q", got)
+	}
+	if got := FromContext(With(context.Background(), "bank-a")); got != "bank-a" {
+		t.Errorf("expected bank-a, got %q", got)
+	}
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// scopeName is the instrumentation scope of the exported spans.
+const scopeName = "sber_test/internal/tracing"
+
+// JSONExporter writes each span as an OTLP/JSON ExportTraceServiceRequest
+// on its own line, the format of the OpenTelemetry Collector's otlpjsonfile
+// receiver and of the file exporter of the OpenTelemetry SDKs. It covers
+// the subset of OTLP this package records: resource service.name, span IDs,
+// name, times, attributes and status. Span kinds, events and links are not
+// written.
+type JSONExporter struct {
+	mu       sync.Mutex
+	enc      *json.Encoder
+	resource otlpResource
+}
+
+// NewJSONExporter returns an exporter writing to w, such as os.Stdout or an
+// open file, spans of the service named serviceName.
+func NewJSONExporter(w io.Writer, serviceName string) *JSONExporter {
+	return &JSONExporter{
+		enc:      json.NewEncoder(w),
+		resource: otlpResource{Attributes: []otlpKeyValue{otlpAttr(String("service.name", serviceName))}},
+	}
+}
+
+// Export writes data.
+func (e *JSONExporter) Export(data SpanData) error {
+	span := otlpSpan{
+		TraceID:           data.TraceID,
+		SpanID:            data.SpanID,
+		ParentSpanID:      data.ParentSpanID,
+		Name:              data.Name,
+		StartTimeUnixNano: strconv.FormatInt(data.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(data.End.UnixNano(), 10),
+		Attributes:        make([]otlpKeyValue, 0, len(data.Attributes)),
+	}
+	keys := make([]string, 0, len(data.Attributes))
+	for key := range data.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		span.Attributes = append(span.Attributes, otlpAttr(Attr{Key: key, Value: data.Attributes[key]}))
+	}
+	if data.Status == StatusError {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: data.Error}
+	}
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: e.resource,
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: scopeName},
+			Spans: []otlpSpan{span},
+		}},
+	}}}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(req) //nolint:wrapcheck // counted by the tracer
+}
+
+// otlpStatusError is STATUS_CODE_ERROR.
+const otlpStatusError = 2
+
+// The OTLP/JSON encoding of ExportTraceServiceRequest: IDs are hex, 64-bit
+// integers are strings and enums are numbers.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func otlpAttr(a Attr) otlpKeyValue {
+	kv := otlpKeyValue{Key: a.Key}
+	switch v := a.Value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case bool:
+		kv.Value.BoolValue = &v
+	case float64:
+		kv.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
@@ -0,0 +1,388 @@
+// Package tracing records spans of work done for a request and propagates
+// them between services with the W3C Trace Context traceparent header.
+//
+// It follows the OpenTelemetry model — a trace is a tree of spans sharing a
+// trace ID, each with a name, a time range, attributes and a status — and
+// exports finished spans as OTLP/JSON lines, which an OpenTelemetry
+// Collector can read and which can be inspected without one.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HeaderTraceparent carries the span context of the caller, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+const HeaderTraceparent = "traceparent"
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// IsValid reports whether id is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid reports whether id is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span that is propagated to its children.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled reports whether the trace is exported.
+	Sampled bool
+}
+
+// IsValid reports whether sc has both a trace and a span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats sc as a traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ErrInvalidTraceparent is returned by ParseTraceparent.
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a traceparent header value. Versions other than
+// 00 are accepted as long as they start with the fields of version 00, as
+// the specification requires.
+func ParseTraceparent(s string) (SpanContext, error) {
+	const size = len("00-") + 32 + 1 + 16 + 1 + 2
+	if len(s) < size || (len(s) > size && (s[:2] == "00" || s[size] != '-')) {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	var sc SpanContext
+	var flags [1]byte
+	ok := s[2] == '-' && s[35] == '-' && s[52] == '-' && s[:2] != "ff" &&
+		decodeHex(sc.TraceID[:], s[3:35]) && decodeHex(sc.SpanID[:], s[36:52]) &&
+		decodeHex(flags[:], s[53:55]) && decodeHex(make([]byte, 1), s[:2])
+	if !ok || !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// decodeHex decodes lower-case hex only, as traceparent requires.
+func decodeHex(dst []byte, s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Extract returns the span context sent in the traceparent header of h.
+func Extract(h http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(h.Get(HeaderTraceparent))
+	return sc, err == nil
+}
+
+// Inject sets the traceparent header of h to the span in ctx, if any.
+func Inject(ctx context.Context, h http.Header) {
+	if sc := SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		h.Set(HeaderTraceparent, sc.Traceparent())
+	}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithRemoteParent returns a copy of ctx in which spans without a
+// local parent continue the trace of sc, received from a caller.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanFromContext returns the current span of ctx, or nil. All Span methods
+// accept a nil receiver.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Attr is a span attribute.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute.
+func String(key, value string) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Float64 returns a floating-point attribute.
+func Float64(key string, value float64) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Span statuses.
+const (
+	StatusUnset = "unset"
+	StatusError = "error"
+)
+
+// SpanData is a finished span as passed to an Exporter.
+type SpanData struct {
+	Name         string         `json:"name"`
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Duration     time.Duration  `json:"duration_ns"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Status       string         `json:"status"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// Exporter receives every sampled span when it ends. Export is called
+// concurrently.
+type Exporter interface {
+	Export(SpanData) error
+}
+
+// Span is a unit of work within a trace. It is safe for concurrent use.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu    sync.Mutex
+	name  string
+	attrs []Attr
+	err   error
+	ended bool
+}
+
+// SpanContext returns the propagated part of s.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName renames s, e.g. once the route of a request is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttributes adds attributes to s.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks s as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// End finishes s and exports it if its trace is sampled. Calls after the
+// first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := SpanData{
+		Name:     s.name,
+		TraceID:  s.sc.TraceID.String(),
+		SpanID:   s.sc.SpanID.String(),
+		Start:    s.start,
+		End:      end,
+		Duration: end.Sub(s.start),
+		Status:   StatusUnset,
+	}
+	if s.parent.IsValid() {
+		data.ParentSpanID = s.parent.String()
+	}
+	if len(s.attrs) > 0 {
+		data.Attributes = make(map[string]any, len(s.attrs))
+		for _, a := range s.attrs {
+			data.Attributes[a.Key] = a.Value
+		}
+	}
+	if s.err != nil {
+		data.Status, data.Error = StatusError, s.err.Error()
+	}
+	s.mu.Unlock()
+
+	if s.sc.Sampled && s.tracer.exporter != nil {
+		s.tracer.export(data)
+	}
+}
+
+// Tracer starts spans and hands the finished ones to its exporter.
+type Tracer struct {
+	exporter Exporter
+	ratio    float64
+	failures atomic.Uint64
+}
+
+// Option configures a Tracer.
+type Option func(*Tracer)
+
+// WithSampleRatio samples the given fraction, from 0 to 1, of the traces
+// started here. Traces continued from a caller keep the caller's decision.
+// The default is 1.
+func WithSampleRatio(ratio float64) Option {
+	return func(t *Tracer) {
+		t.ratio = ratio
+	}
+}
+
+// New returns a Tracer exporting to exporter. A nil exporter discards
+// spans, but IDs are still generated and propagated.
+func New(exporter Exporter, opts ...Option) *Tracer {
+	t := &Tracer{exporter: exporter, ratio: 1}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// Default returns the tracer used by Start. Until SetDefault is called it
+// is a tracer discarding spans.
+func Default() *Tracer {
+	if t := defaultTracer.Load(); t != nil {
+		return t
+	}
+	defaultTracer.CompareAndSwap(nil, New(nil))
+	return defaultTracer.Load()
+}
+
+// SetDefault makes t the tracer used by Start.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Start starts a span with the default tracer. See Tracer.Start.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return Default().Start(ctx, name, attrs...)
+}
+
+// Start starts a span named name as a child of the span in ctx, or of the
+// remote parent in ctx, or else as the root of a new trace. It returns a
+// copy of ctx holding the new span; the caller must End it.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	s := &Span{tracer: t, name: name, attrs: attrs, start: time.Now()}
+	parent := SpanFromContext(ctx).SpanContext()
+	if !parent.IsValid() {
+		parent, _ = ctx.Value(remoteKey{}).(SpanContext)
+	}
+	if parent.IsValid() {
+		s.sc = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+		s.parent = parent.SpanID
+		s.sc.SpanID = newSpanID()
+	} else {
+		s.sc.TraceID, s.sc.SpanID = newTraceID(), newSpanID()
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// sample decides on a new trace from the low bits of its ID, which are
+// random, so that all services sampling at the same ratio agree.
+func (t *Tracer) sample(id TraceID) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	bound := uint64(t.ratio * (1 << 63))
+	return binary.BigEndian.Uint64(id[8:])>>1 < bound
+}
+
+func (t *Tracer) export(data SpanData) {
+	if err := t.exporter.Export(data); err != nil {
+		t.failures.Add(1)
+	}
+}
+
+// ExportFailures returns the number of spans the exporter failed to take.
+func (t *Tracer) ExportFailures() uint64 {
+	return t.failures.Load()
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:]) //nolint:errcheck // crypto/rand doesn't fail on supported platforms
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:]) //nolint:errcheck // crypto/rand doesn't fail on supported platforms
+	}
+	return id
+}
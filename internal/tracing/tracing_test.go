@@ -0,0 +1,202 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// recorder collects exported spans.
+type recorder struct {
+	spans []SpanData
+}
+
+func (r *recorder) Export(data SpanData) error {
+	r.spans = append(r.spans, data)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("unexpected span context %+v", sc)
+	}
+	if got := sc.Traceparent(); got != valid {
+		t.Errorf("expected %s formatted back, got %s", valid, got)
+	}
+
+	for _, s := range []string{
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	} {
+		if sc, err := ParseTraceparent(s); err != nil || sc.Sampled {
+			t.Errorf("%s: expected a later version accepted as not sampled, got %+v, %v", s, sc, err)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01x",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		if _, err := ParseTraceparent(s); !errors.Is(err, ErrInvalidTraceparent) {
+			t.Errorf("%q: expected ErrInvalidTraceparent, got %v", s, err)
+		}
+	}
+}
+
+func TestSpanTree(t *testing.T) {
+	var rec recorder
+	tracer := New(&rec)
+
+	ctx, root := tracer.Start(context.Background(), "request", String("route", "/execute"))
+	_, child := tracer.Start(ctx, "calculate")
+	child.SetAttributes(Int("months", 12), Bool("cached", false))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End()
+	root.SetName("GET /execute")
+	root.End()
+
+	if len(rec.spans) != 2 {
+		t.Fatalf("expected 2 spans exported once each, got %d", len(rec.spans))
+	}
+	c, r := rec.spans[0], rec.spans[1]
+	if r.Name != "GET /execute" || r.ParentSpanID != "" || r.Attributes["route"] != "/execute" || r.Status != StatusUnset {
+		t.Errorf("unexpected root span %+v", r)
+	}
+	if c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID || c.SpanID == r.SpanID {
+		t.Errorf("expected the child in the root's trace, got %+v and %+v", c, r)
+	}
+	if c.Status != StatusError || c.Error != "boom" || c.Attributes["months"] != 12 || c.Attributes["cached"] != false {
+		t.Errorf("unexpected child span %+v", c)
+	}
+	if c.Duration < 0 || c.End.Before(c.Start) {
+		t.Errorf("unexpected time range %v..%v", c.Start, c.End)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	var rec recorder
+	tracer := New(&rec, WithSampleRatio(0))
+
+	h := http.Header{}
+	h.Set(HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	remote, ok := Extract(h)
+	if !ok {
+		t.Fatal("expected the traceparent extracted")
+	}
+	ctx, span := tracer.Start(ContextWithRemoteParent(context.Background(), remote), "request")
+	out := http.Header{}
+	Inject(ctx, out)
+	span.End()
+
+	sc, err := ParseTraceparent(out.Get(HeaderTraceparent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID != remote.TraceID || sc.SpanID == remote.SpanID || !sc.Sampled {
+		t.Errorf("expected the caller's trace and sampling continued, got %+v", sc)
+	}
+	if len(rec.spans) != 1 || rec.spans[0].ParentSpanID != remote.SpanID.String() {
+		t.Errorf("expected one span with the remote parent, got %+v", rec.spans)
+	}
+
+	_, root := tracer.Start(context.Background(), "unsampled")
+	root.End()
+	if len(rec.spans) != 1 {
+		t.Error("expected a new trace not sampled at ratio 0")
+	}
+	if _, ok := Extract(http.Header{}); ok {
+		t.Error("expected nothing extracted without a header")
+	}
+}
+
+func TestNilSpan(t *testing.T) {
+	var s *Span
+	s.SetName("x")
+	s.SetAttributes(String("k", "v"))
+	s.RecordError(errors.New("boom"))
+	s.End()
+	if s.SpanContext().IsValid() {
+		t.Error("expected an invalid span context")
+	}
+	if SpanFromContext(context.Background()) != nil {
+		t.Error("expected no span in an empty context")
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := New(NewJSONExporter(&buf, "calculator"))
+	ctx, parent := tracer.Start(context.Background(), "request")
+	_, span := tracer.Start(ctx, "work", Int("i", 1), String("s", "x"), Bool("b", true))
+	span.RecordError(errors.New("boom"))
+	span.End()
+	parent.End()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf.String())
+	}
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]any `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("expected one span per request, got %s", lines[0])
+	}
+	resource, _ := json.Marshal(req.ResourceSpans[0].Resource.Attributes) //nolint:errcheck // decoded JSON
+	if string(resource) != `[{"key":"service.name","value":{"stringValue":"calculator"}}]` {
+		t.Errorf("unexpected resource %s", resource)
+	}
+	got := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	sc := span.SpanContext()
+	attrs, _ := json.Marshal(got["attributes"]) //nolint:errcheck // decoded JSON
+	if got["name"] != "work" || got["traceId"] != sc.TraceID.String() || got["spanId"] != sc.SpanID.String() ||
+		got["parentSpanId"] != parent.SpanContext().SpanID.String() {
+		t.Errorf("unexpected exported span %s", lines[0])
+	}
+	if want := `[{"key":"b","value":{"boolValue":true}},{"key":"i","value":{"intValue":"1"}},{"key":"s","value":{"stringValue":"x"}}]`; string(attrs) != want {
+		t.Errorf("expected attributes %s, got %s", want, attrs)
+	}
+	status, _ := json.Marshal(got["status"]) //nolint:errcheck // decoded JSON
+	if string(status) != `{"code":2,"message":"boom"}` {
+		t.Errorf("unexpected status %s", status)
+	}
+	start, _ := got["startTimeUnixNano"].(string)
+	end, _ := got["endTimeUnixNano"].(string)
+	if start == "" || end == "" || len(start) != len(end) || start > end {
+		t.Errorf("expected nanosecond timestamps as strings, got %q and %q", start, end)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	if Default() == nil || Default() != Default() {
+		t.Error("expected a default tracer before SetDefault")
+	}
+}
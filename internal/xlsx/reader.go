@@ -0,0 +1,321 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by ReadRows.
+var (
+	ErrNoSheet   = errors.New("xlsx: workbook has no sheets")
+	ErrMalformed = errors.New("xlsx: malformed workbook")
+	// ErrTooLarge means the workbook exceeds the Limits or decompresses to
+	// far more than its size.
+	ErrTooLarge = errors.New("xlsx: workbook too large")
+)
+
+const (
+	// maxUncompressed caps the uncompressed size of all workbook parts read.
+	maxUncompressed = 64 << 20
+	// maxExpansion caps the uncompressed size of the parts read relative to
+	// the size of the archive, so that a small zip bomb is rejected early.
+	maxExpansion = 100
+)
+
+// Limits bound what ReadRows returns. Blank rows and cells padding gaps in
+// the sheet count against them like any other.
+type Limits struct {
+	// MaxRows is the most rows a sheet may have.
+	MaxRows int
+	// MaxColumns is the number of leading columns read; cells beyond it are
+	// dropped.
+	MaxColumns int
+	// MaxCells is the most cells a sheet may have in total.
+	MaxCells int
+}
+
+// ReadRows returns the cell values of the first worksheet as text.
+// Row i of the result is spreadsheet row i+1, and cell j is column j, so
+// gaps in the sheet are kept as empty strings. Booleans read as "TRUE"/"FALSE".
+// Sheets exceeding limits are rejected with ErrTooLarge.
+func ReadRows(r io.ReaderAt, size int64, limits Limits) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("xlsx: open archive: %w", err)
+	}
+	budget := &budget{left: min(size*maxExpansion, maxUncompressed)}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	sheetPath, err := firstSheet(files, budget)
+	if err != nil {
+		return nil, err
+	}
+	var shared []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if shared, err = readSharedStrings(f, budget); err != nil {
+			return nil, err
+		}
+	}
+	f, ok := files[sheetPath]
+	if !ok {
+		return nil, fmt.Errorf("xlsx: missing part %s: %w", sheetPath, ErrNoSheet)
+	}
+	return readSheet(f, budget, shared, limits)
+}
+
+// budget is the number of uncompressed bytes ReadRows may still read.
+type budget struct {
+	left int64
+}
+
+// reader returns r limited to the budget. Reading past it fails with
+// ErrTooLarge.
+func (b *budget) reader(r io.Reader) io.Reader {
+	return &budgetReader{r: r, b: b}
+}
+
+type budgetReader struct {
+	r io.Reader
+	b *budget
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	if br.b.left <= 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > br.b.left {
+		p = p[:br.b.left]
+	}
+	n, err := br.r.Read(p)
+	br.b.left -= int64(n)
+	return n, err //nolint:wrapcheck // passed through to the XML decoder
+}
+
+type relationship struct {
+	ID     string `xml:"Id,attr"`
+	Target string `xml:"Target,attr"`
+}
+
+func firstSheet(files map[string]*zip.File, b *budget) (string, error) {
+	var wb struct {
+		Sheets []struct {
+			RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodePart(files, b, "xl/workbook.xml", &wb); err != nil {
+		return "", err
+	}
+	if len(wb.Sheets) == 0 {
+		return "", ErrNoSheet
+	}
+	var rels struct {
+		Relationships []relationship `xml:"Relationship"`
+	}
+	if err := decodePart(files, b, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", err
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != wb.Sheets[0].RID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return "xl/worksheets/sheet1.xml", nil
+}
+
+func decodePart(files map[string]*zip.File, b *budget, name string, v any) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("%w: missing part %s", ErrMalformed, name)
+	}
+	return decodeFile(f, b, v)
+}
+
+func decodeFile(f *zip.File, b *budget, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("xlsx: open %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(b.reader(rc)).Decode(v); err != nil {
+		return parseError(f, err)
+	}
+	return nil
+}
+
+func parseError(f *zip.File, err error) error {
+	if errors.Is(err, ErrTooLarge) {
+		return fmt.Errorf("%w: uncompressed parts exceed the limit at %s", ErrTooLarge, f.Name)
+	}
+	return fmt.Errorf("xlsx: parse %s: %w", f.Name, err)
+}
+
+// richText is a string item: either plain <t> or a run of <r><t>.
+type richText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (rt richText) String() string {
+	if len(rt.Runs) == 0 {
+		return rt.T
+	}
+	var b strings.Builder
+	for _, r := range rt.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+func readSharedStrings(f *zip.File, b *budget) ([]string, error) {
+	var sst struct {
+		Items []richText `xml:"si"`
+	}
+	if err := decodeFile(f, b, &sst); err != nil {
+		return nil, err
+	}
+	out := make([]string, len(sst.Items))
+	for i, si := range sst.Items {
+		out[i] = si.String()
+	}
+	return out, nil
+}
+
+type xmlCell struct {
+	Ref    string   `xml:"r,attr"`
+	Type   string   `xml:"t,attr"`
+	Value  string   `xml:"v"`
+	Inline richText `xml:"is"`
+}
+
+type xmlRow struct {
+	Num   int       `xml:"r,attr"`
+	Cells []xmlCell `xml:"c"`
+}
+
+// readSheet decodes the rows of a worksheet one at a time, so that limits
+// apply before a large sheet is held in memory.
+func readSheet(f *zip.File, b *budget, shared []string, limits Limits) ([][]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("xlsx: open %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	dec := xml.NewDecoder(b.reader(rc))
+	var out [][]string
+	cells := 0
+	for i := 0; ; {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, parseError(f, err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		var row xmlRow
+		if err := dec.DecodeElement(&row, &start); err != nil {
+			return nil, parseError(f, err)
+		}
+
+		num := row.Num
+		if num == 0 {
+			num = len(out) + 1
+		}
+		if num < len(out)+1 {
+			return nil, fmt.Errorf("%w: row %d out of order at position %d", ErrMalformed, num, i)
+		}
+		if num > limits.MaxRows {
+			return nil, fmt.Errorf("%w: row %d exceeds the limit of %d rows", ErrTooLarge, num, limits.MaxRows)
+		}
+		for len(out) < num {
+			out = append(out, nil)
+		}
+		values, err := rowValues(row, shared, limits.MaxColumns)
+		if err != nil {
+			return nil, err
+		}
+		if cells += len(values); cells > limits.MaxCells {
+			return nil, fmt.Errorf("%w: more than %d cells", ErrTooLarge, limits.MaxCells)
+		}
+		out[num-1] = values
+		i++
+	}
+}
+
+// rowValues returns the values of the first maxColumns cells of row, with
+// gaps kept as empty strings.
+func rowValues(row xmlRow, shared []string, maxColumns int) ([]string, error) {
+	values := make([]string, 0, min(len(row.Cells), maxColumns))
+	for _, c := range row.Cells {
+		col := len(values)
+		if c.Ref != "" {
+			col = columnIndex(c.Ref)
+		}
+		if col < len(values) {
+			return nil, fmt.Errorf("%w: cell %s out of order", ErrMalformed, c.Ref)
+		}
+		if col >= maxColumns {
+			break
+		}
+		for len(values) < col {
+			values = append(values, "")
+		}
+		v, err := cellValue(c, shared)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func cellValue(c xmlCell, shared []string) (string, error) {
+	switch c.Type {
+	case "s":
+		i, err := strconv.Atoi(c.Value)
+		if err != nil || i < 0 || i >= len(shared) {
+			return "", fmt.Errorf("%w: cell %s: bad shared string index %q", ErrMalformed, c.Ref, c.Value)
+		}
+		return shared[i], nil
+	case "inlineStr":
+		return c.Inline.String(), nil
+	case "b":
+		if c.Value == "1" {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	default:
+		return c.Value, nil
+	}
+}
+
+// columnIndex returns the zero-based column of a cell reference such as "C12".
+func columnIndex(ref string) int {
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		n = n*26 + int(r-'A'+1)
+	}
+	return n - 1
+}
@@ -0,0 +1,285 @@
+// Package xlsx reads and writes minimal Office Open XML workbooks using only
+// the standard library.
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned by Writer.
+var (
+	ErrSheetOpen = errors.New("xlsx: previous sheet is still open")
+	ErrClosed    = errors.New("xlsx: writer is closed")
+)
+
+type cellKind int
+
+const (
+	kindString cellKind = iota
+	kindNumber
+	kindBool
+)
+
+// Cell styles, indexes into cellXfs in styles.xml.
+const (
+	styleGeneral = iota
+	styleMoney
+	styleDate
+	styleHeader
+)
+
+// Cell is a single typed spreadsheet cell.
+type Cell struct {
+	str   string
+	num   float64
+	kind  cellKind
+	style int
+}
+
+// String returns a text cell.
+func String(s string) Cell { return Cell{kind: kindString, str: s} }
+
+// Header returns a bold text cell.
+func Header(s string) Cell { return Cell{kind: kindString, str: s, style: styleHeader} }
+
+// Number returns a numeric cell in the General format.
+func Number(f float64) Cell { return Cell{kind: kindNumber, num: f} }
+
+// Int returns an integer cell.
+func Int(n int) Cell { return Number(float64(n)) }
+
+// Money returns a numeric cell formatted with two decimals.
+func Money(f float64) Cell { return Cell{kind: kindNumber, num: f, style: styleMoney} }
+
+// Bool returns a boolean cell.
+func Bool(b bool) Cell {
+	c := Cell{kind: kindBool}
+	if b {
+		c.num = 1
+	}
+	return c
+}
+
+// Date returns a date cell. Dates are stored as spreadsheet serial numbers.
+func Date(t time.Time) Cell {
+	return Cell{kind: kindNumber, num: serial(t), style: styleDate}
+}
+
+var epoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func serial(t time.Time) float64 {
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return day.Sub(epoch).Hours() / 24
+}
+
+// Writer streams a workbook into an io.Writer. Sheets are written one
+// after another; rows are not buffered beyond a small write buffer.
+type Writer struct {
+	zw     *zip.Writer
+	sheets []string
+	open   *Sheet
+	closed bool
+}
+
+// NewWriter returns a Writer writing a workbook to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w)}
+}
+
+// Sheet is a worksheet being written.
+type Sheet struct {
+	w   *Writer
+	buf *bufio.Writer
+	row int
+}
+
+// NewSheet starts a new worksheet. The previous sheet must be closed first.
+func (w *Writer) NewSheet(name string) (*Sheet, error) {
+	if w.closed {
+		return nil, ErrClosed
+	}
+	if w.open != nil {
+		return nil, ErrSheetOpen
+	}
+	part, err := w.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(w.sheets)+1))
+	if err != nil {
+		return nil, fmt.Errorf("xlsx: create sheet: %w", err)
+	}
+	w.sheets = append(w.sheets, name)
+	s := &Sheet{w: w, buf: bufio.NewWriter(part)}
+	w.open = s
+	if _, err := s.buf.WriteString(xml.Header + `<worksheet xmlns="` + nsMain + `"><sheetData>`); err != nil {
+		return nil, fmt.Errorf("xlsx: write sheet: %w", err)
+	}
+	return s, nil
+}
+
+// WriteRow appends a row of cells to the sheet.
+func (s *Sheet) WriteRow(cells ...Cell) error {
+	s.row++
+	b := s.buf
+	b.WriteString(`<row r="`)
+	b.WriteString(strconv.Itoa(s.row))
+	b.WriteString(`">`)
+	for i, c := range cells {
+		ref := columnName(i) + strconv.Itoa(s.row)
+		b.WriteString(`<c r="`)
+		b.WriteString(ref)
+		b.WriteString(`"`)
+		if c.style != styleGeneral {
+			b.WriteString(` s="`)
+			b.WriteString(strconv.Itoa(c.style))
+			b.WriteString(`"`)
+		}
+		switch c.kind {
+		case kindString:
+			b.WriteString(` t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(b, []byte(c.str)); err != nil {
+				return fmt.Errorf("xlsx: write cell %s: %w", ref, err)
+			}
+			b.WriteString(`</t></is></c>`)
+		case kindBool:
+			b.WriteString(` t="b"><v>`)
+			b.WriteString(strconv.FormatFloat(c.num, 'f', -1, 64))
+			b.WriteString(`</v></c>`)
+		case kindNumber:
+			b.WriteString(`><v>`)
+			b.WriteString(strconv.FormatFloat(c.num, 'f', -1, 64))
+			b.WriteString(`</v></c>`)
+		}
+	}
+	if _, err := b.WriteString(`</row>`); err != nil {
+		return fmt.Errorf("xlsx: write row %d: %w", s.row, err)
+	}
+	return nil
+}
+
+// Close finishes the sheet.
+func (s *Sheet) Close() error {
+	if s.w.open != s {
+		return nil
+	}
+	s.w.open = nil
+	s.buf.WriteString(`</sheetData></worksheet>`)
+	if err := s.buf.Flush(); err != nil {
+		return fmt.Errorf("xlsx: finish sheet: %w", err)
+	}
+	return nil
+}
+
+// Close writes the workbook parts and finishes the archive.
+// An open sheet is closed first.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if w.open != nil {
+		if err := w.open.Close(); err != nil {
+			return err
+		}
+	}
+	w.closed = true
+	parts := []struct {
+		name string
+		body string
+	}{
+		{"[Content_Types].xml", w.contentTypes()},
+		{"_rels/.rels", rootRels},
+		{"xl/workbook.xml", w.workbook()},
+		{"xl/_rels/workbook.xml.rels", w.workbookRels()},
+		{"xl/styles.xml", styles},
+	}
+	for _, p := range parts {
+		f, err := w.zw.Create(p.name)
+		if err != nil {
+			return fmt.Errorf("xlsx: create %s: %w", p.name, err)
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return fmt.Errorf("xlsx: write %s: %w", p.name, err)
+		}
+	}
+	if err := w.zw.Close(); err != nil {
+		return fmt.Errorf("xlsx: finish archive: %w", err)
+	}
+	return nil
+}
+
+// columnName converts a zero-based column index to its letter name (A, B, ..., AA).
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+const (
+	nsMain = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	nsRel  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	nsPkg  = "http://schemas.openxmlformats.org/package/2006/relationships"
+
+	rootRels = xml.Header + `<Relationships xmlns="` + nsPkg + `">` +
+		`<Relationship Id="rId1" Type="` + nsRel + `/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+
+	styles = xml.Header + `<styleSheet xmlns="` + nsMain + `">` +
+		`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy\-mm\-dd"/></numFmts>` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font>` +
+		`<font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill>` +
+		`<fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="4">` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="4" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+		`</cellXfs>` +
+		`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+		`</styleSheet>`
+)
+
+func (w *Writer) contentTypes() string {
+	s := xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`
+	for i := range w.sheets {
+		s += fmt.Sprintf(`<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+	return s + `</Types>`
+}
+
+func (w *Writer) workbook() string {
+	s := xml.Header + `<workbook xmlns="` + nsMain + `" xmlns:r="` + nsRel + `"><sheets>`
+	for i, name := range w.sheets {
+		s += fmt.Sprintf(`<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(name), i+1, i+1)
+	}
+	return s + `</sheets></workbook>`
+}
+
+func (w *Writer) workbookRels() string {
+	s := xml.Header + `<Relationships xmlns="` + nsPkg + `">`
+	for i := range w.sheets {
+		s += fmt.Sprintf(`<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, nsRel, i+1)
+	}
+	return s + fmt.Sprintf(`<Relationship Id="rId%d" Type="%s/styles" Target="styles.xml"/>`, len(w.sheets)+1, nsRel) +
+		`</Relationships>`
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s)) //nolint:errcheck // strings.Builder never fails
+	return b.String()
+}
@@ -0,0 +1,160 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColumnName(t *testing.T) {
+	assert.Equal(t, "A", columnName(0))
+	assert.Equal(t, "Z", columnName(25))
+	assert.Equal(t, "AA", columnName(26))
+	assert.Equal(t, "AZ", columnName(51))
+	assert.Equal(t, "BA", columnName(52))
+}
+
+func TestDateSerial(t *testing.T) {
+	assert.Equal(t, 45292.0, serial(time.Date(2024, 1, 1, 15, 0, 0, 0, time.UTC)))
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	sheet, err := w.NewSheet("Data & more")
+	assert.Nil(t, err)
+	_, err = w.NewSheet("Second")
+	assert.ErrorIs(t, err, ErrSheetOpen)
+
+	assert.Nil(t, sheet.WriteRow(Header("name"), Header("value")))
+	assert.Nil(t, sheet.WriteRow(String("a <b>"), Money(1.5), Bool(true), Date(time.Now())))
+	assert.Nil(t, sheet.Close())
+
+	second, err := w.NewSheet("Second")
+	assert.Nil(t, err)
+	assert.Nil(t, second.WriteRow(Int(1)))
+	assert.Nil(t, w.Close())
+
+	_, err = w.NewSheet("Third")
+	assert.ErrorIs(t, err, ErrClosed)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+		rc, err := f.Open()
+		assert.Nil(t, err)
+		data, err := io.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.Nil(t, rc.Close())
+		assert.Nil(t, wellFormed(data), "%s should be well-formed XML", f.Name)
+	}
+	for _, name := range []string{
+		"[Content_Types].xml",
+		"_rels/.rels",
+		"xl/workbook.xml",
+		"xl/_rels/workbook.xml.rels",
+		"xl/styles.xml",
+		"xl/worksheets/sheet1.xml",
+		"xl/worksheets/sheet2.xml",
+	} {
+		assert.True(t, names[name], "missing part %s", name)
+	}
+}
+
+func wellFormed(data []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		if _, err := d.Token(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+var testLimits = Limits{MaxRows: 100, MaxColumns: 10, MaxCells: 500}
+
+func TestReadRows(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	sheet, err := w.NewSheet("Loans")
+	assert.Nil(t, err)
+	assert.Nil(t, sheet.WriteRow(String("object_cost"), String("months"), String("salary")))
+	assert.Nil(t, sheet.WriteRow(Number(5000000), Int(240), Bool(true)))
+	assert.Nil(t, w.Close())
+
+	rows, err := ReadRows(bytes.NewReader(buf.Bytes()), int64(buf.Len()), testLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"object_cost", "months", "salary"},
+		{"5000000", "240", "TRUE"},
+	}, rows)
+}
+
+func TestReadRowsExample(t *testing.T) {
+	f, err := os.Open("../../example_golang.xlsx")
+	assert.Nil(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	assert.Nil(t, err)
+
+	rows, err := ReadRows(f, info.Size(), testLimits)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"", "", "object_cost", "5000000", "", "rate", "0.08"}, rows[2], "shared strings and gaps should be resolved")
+	assert.Equal(t, []string{"", "", "salary", "TRUE", "", "overpayment", "4029920"}, rows[5])
+}
+
+func TestReadRowsNotAWorkbook(t *testing.T) {
+	_, err := ReadRows(bytes.NewReader([]byte("nope")), 4, testLimits)
+	assert.NotNil(t, err)
+}
+
+// workbook returns a workbook whose first sheet has the given sheetData.
+func workbook(t *testing.T, sheetData string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml":   `<worksheet><sheetData>` + sheetData + `</sheetData></worksheet>`,
+	} {
+		w, err := zw.Create(name)
+		assert.Nil(t, err)
+		_, err = io.WriteString(w, data)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestReadRowsLimits(t *testing.T) {
+	read := func(data []byte) ([][]string, error) {
+		return ReadRows(bytes.NewReader(data), int64(len(data)), testLimits)
+	}
+
+	rows, err := read(workbook(t, `<row><c r="A1"><v>1</v></c><c r="XFD1"><v>2</v></c></row>`))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"1"}}, rows, "cells beyond MaxColumns should be dropped, not padded")
+
+	_, err = read(workbook(t, `<row r="101"><c><v>1</v></c></row>`))
+	assert.ErrorIs(t, err, ErrTooLarge, "rows are counted with the blank rows before them")
+
+	_, err = read(workbook(t, strings.Repeat(`<row><c r="J1"><v>1</v></c></row>`, 51)))
+	assert.ErrorIs(t, err, ErrTooLarge, "padding cells count against MaxCells")
+
+	_, err = read(workbook(t, `<row><c r="A1"/></row>`+strings.Repeat(" ", 10<<20)))
+	assert.ErrorIs(t, err, ErrTooLarge, "a part decompressing to far more than the archive should be rejected")
+}